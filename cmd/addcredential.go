@@ -20,23 +20,34 @@ var credentialCmd = &cobra.Command{
 }
 
 var scanIntervalDays int
+var matchRules []string
 
 var credentialAddCmd = &cobra.Command{
 	Use:     "add",
 	Aliases: []string{"addcredential", "ac", "add"},
 	Short:   "add a new credential pair",
-	Example: "add root root123",
+	Example: "add root root123\nadd cisco cisco --match banner=Cisco --match cidr=10.1.0.0/16",
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 2 {
 			cmd.Usage()
 			return
 		}
+		var rules []sshauditor.CredentialRule
+		for _, spec := range matchRules {
+			r, err := sshauditor.ParseCredentialRule(spec)
+			if err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+			rules = append(rules, r)
+		}
 		cred := sshauditor.Credential{
 			User:         args[0],
 			Password:     args[1],
 			ScanInterval: scanIntervalDays,
+			Rules:        sshauditor.JoinCredentialRules(rules),
 		}
-		l := log.New("user", cred.User, "password", cred.Password, "interval", scanIntervalDays)
+		l := log.New("user", cred.User, "password", cred.Password, "interval", scanIntervalDays, "rules", len(rules))
 		added, err := store.AddCredential(cred)
 		if err != nil {
 			log.Error(err.Error())
@@ -68,6 +79,37 @@ var credentialListCmd = &cobra.Command{
 	},
 }
 
+var credentialRulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "list the rules restricting which hosts credentials are tried against",
+	Long: `List the rules restricting which hosts credentials are tried against,
+one line per rule in the format of
+user	password	type=pattern
+
+Credentials without rules are tried against every host.  Rules are set with
+credential add --match and replaced whenever the credential is added again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := store.GetAllCreds()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		w := csv.NewWriter(os.Stdout)
+		w.Comma = '\t'
+		for _, c := range creds {
+			rules, err := c.MatchRules()
+			if err != nil {
+				log.Error(err.Error(), "user", c.User)
+				continue
+			}
+			for _, r := range rules {
+				w.Write([]string{c.User, c.Password, r.String()})
+			}
+		}
+		w.Flush()
+	},
+}
+
 var credentialResetCmd = &cobra.Command{
 	Use:     "reset",
 	Aliases: []string{"c"},
@@ -152,6 +194,7 @@ var credentialImportJSONCmd = &cobra.Command{
 	Long: `Load credentials from stdin in the format of
 {"User":"root","Password":"root","ScanInterval":7}
 {"User":"test","Password":"test"}
+{"User":"cisco","Password":"cisco","Rules":"banner=Cisco\nport=22"}
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		store.Begin()
@@ -183,10 +226,12 @@ var credentialImportJSONCmd = &cobra.Command{
 
 func init() {
 	credentialAddCmd.Flags().IntVar(&scanIntervalDays, "scan-interval", 14, "How often to re-scan for this credential, in days")
+	credentialAddCmd.Flags().StringArrayVar(&matchRules, "match", nil, "Only try this credential against matching hosts, type=pattern where type is cidr, port, banner (regular expression) or auth (advertised method)")
 	RootCmd.AddCommand(credentialAddCmd)
 	RootCmd.AddCommand(credentialCmd)
 	credentialCmd.AddCommand(credentialAddCmd)
 	credentialCmd.AddCommand(credentialListCmd)
+	credentialCmd.AddCommand(credentialRulesCmd)
 	credentialCmd.AddCommand(credentialResetCmd)
 	credentialCmd.AddCommand(credentialImportCmd)
 	credentialImportCmd.AddCommand(credentialImportTSVCmd)
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "manage the database",
+	// Open the store, but leave migrating to the subcommands
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		initLogging()
+		openStore()
+		return nil
+	},
+}
+
+var migrateStatus bool
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate the database schema to the current version",
+	Run: func(cmd *cobra.Command, args []string) {
+		current, target, err := store.SchemaVersion()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		if migrateStatus {
+			if current == sshauditor.SchemaUnversioned {
+				fmt.Printf("current\tunversioned\ntarget\t%d\n", target)
+				return
+			}
+			fmt.Printf("current\t%d\ntarget\t%d\n", current, target)
+			if current > target {
+				log.Error("database schema is newer than this version of ssh-auditor supports")
+				os.Exit(1)
+			}
+			return
+		}
+		err = store.Init()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		log.Info("database schema is up to date", "version", target)
+	},
+}
+
+var dbEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "encrypt stored passwords and keys using the configured passphrase",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := store.Init()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		passphrase, err := readPassphrase(passphraseFile, "SSH_AUDITOR_PASSPHRASE")
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		if len(passphrase) == 0 {
+			log.Error("a passphrase is required, use --passphrase-file or $SSH_AUDITOR_PASSPHRASE")
+			os.Exit(1)
+		}
+		err = store.Encrypt(passphrase)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		log.Info("database encrypted")
+	},
+}
+
+var newPassphraseFile string
+
+var dbRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "re-encrypt stored passwords and keys using a new passphrase",
+	Run: func(cmd *cobra.Command, args []string) {
+		initStore()
+		passphrase, err := readPassphrase(newPassphraseFile, "SSH_AUDITOR_NEW_PASSPHRASE")
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		if len(passphrase) == 0 {
+			log.Error("a new passphrase is required, use --new-passphrase-file or $SSH_AUDITOR_NEW_PASSPHRASE")
+			os.Exit(1)
+		}
+		err = store.Rekey(passphrase)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		log.Info("database rekeyed")
+	},
+}
+
+func init() {
+	dbMigrateCmd.Flags().BoolVar(&migrateStatus, "status", false, "only report the current and target schema versions")
+	RootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbEncryptCmd)
+	dbRekeyCmd.Flags().StringVar(&newPassphraseFile, "new-passphrase-file", "", "File containing the new passphrase, defaults to $SSH_AUDITOR_NEW_PASSPHRASE")
+	dbCmd.AddCommand(dbRekeyCmd)
+}
@@ -2,37 +2,76 @@ package cmd
 
 import (
 	"bufio"
-	"os"
-	"time"
-	log "github.com/inconshreveable/log15"
+	"fmt"
+	"io"
 	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
 	"github.com/spf13/cobra"
+	"os"
+	"time"
 )
 
-var ports []int
+var ports []string
 var exclude []string
 var timeoutDiscoverMs int
+var seedFiles []string
+var allowLargeIPv6 bool
+
+//readSeedFiles returns the IPv6 addresses listed in the --seed-file files
+func readSeedFiles() ([]string, error) {
+	var seeds []string
+	for _, fn := range seedFiles {
+		f, err := os.Open(fn)
+		if err != nil {
+			return seeds, err
+		}
+		addrs, err := sshauditor.ParseIPv6Seeds(f)
+		f.Close()
+		if err != nil {
+			return seeds, err
+		}
+		log.Info("loaded IPv6 seeds", "file", fn, "count", len(addrs))
+		seeds = append(seeds, addrs...)
+	}
+	return seeds, nil
+}
+
+//parsePortsFlag returns the ports given with --ports, exiting on errors
+func parsePortsFlag() []int {
+	portList, err := sshauditor.ParsePorts(ports)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	return portList
+}
 
 var discoverCmd = &cobra.Command{
 	Use:     "discover",
 	Aliases: []string{"d"},
-	Example: "discover -p 22 -p 2222 192.168.1.0/24 10.1.1.0/24 --exclude 192.168.1.100/32",
+	Example: "discover -p 22,2200-2299 192.168.1.0/24 10.1.1-3.0/24 10.0.0.* 2001:db8::/120 bastion.example.com --exclude 192.168.1.100-150",
 	Short:   "discover new hosts",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 {
+		if len(args) == 0 && len(seedFiles) == 0 {
 			cmd.Usage()
 			return
 		}
+		seeds, err := readSeedFiles()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
 		timeoutDuration := time.Duration(timeoutDiscoverMs) * time.Millisecond
 		scanConfig := sshauditor.ScanConfiguration{
-			Concurrency: concurrency,
-			Include:     args,
-			Exclude:     exclude,
-			Ports:       ports,
-			Timeout: timeoutDuration,
+			Concurrency:    concurrency,
+			Include:        append(args, seeds...),
+			Exclude:        exclude,
+			Ports:          parsePortsFlag(),
+			Timeout:        timeoutDuration,
+			AllowLargeIPv6: allowLargeIPv6,
 		}
 		auditor := sshauditor.New(store)
-		err := auditor.Discover(scanConfig)
+		err = auditor.Discover(interruptContext(), scanConfig)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
@@ -45,20 +84,27 @@ var discoverFromFileCmd = &cobra.Command{
 	Example: "fromfile -p 22 hosts.txt",
 	Short:   "discover new hosts using a list of hosts from stdin",
 	Run: func(cmd *cobra.Command, args []string) {
+		seeds, err := readSeedFiles()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
 		scanner := bufio.NewScanner(os.Stdin)
 		timeoutDuration := time.Duration(timeoutDiscoverMs) * time.Millisecond
 		scanConfig := sshauditor.ScanConfiguration{
-			Concurrency: concurrency,
-			Include:     []string{},
-			Ports:       ports,
-			Timeout: timeoutDuration,
+			Concurrency:    concurrency,
+			Include:        seeds,
+			Exclude:        exclude,
+			Ports:          parsePortsFlag(),
+			Timeout:        timeoutDuration,
+			AllowLargeIPv6: allowLargeIPv6,
 		}
 		for scanner.Scan() {
 			host := scanner.Text()
 			scanConfig.Include = append(scanConfig.Include, host)
 		}
 		auditor := sshauditor.New(store)
-		err := auditor.Discover(scanConfig)
+		err = auditor.Discover(interruptContext(), scanConfig)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
@@ -66,13 +112,75 @@ var discoverFromFileCmd = &cobra.Command{
 	},
 }
 
+var discoverImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "discover hosts from the output of another scanner",
+}
+
+//importDiscovery returns a command that imports the output of a scanner
+//using parse
+func importDiscovery(name string, example string, parse func(io.Reader) ([]sshauditor.DiscoveredPort, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:     name,
+		Example: example,
+		Short:   fmt.Sprintf("discover hosts from %s output, - for stdin", name),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				cmd.Usage()
+				return
+			}
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					log.Error(err.Error())
+					os.Exit(1)
+				}
+				defer f.Close()
+				r = f
+			}
+			found, err := parse(r)
+			if err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+			scanConfig := sshauditor.ScanConfiguration{
+				Concurrency: concurrency,
+				Exclude:     exclude,
+				Ports:       parsePortsFlag(),
+			}
+			auditor := sshauditor.New(store)
+			err = auditor.DiscoverImported(interruptContext(), name+":"+args[0], scanConfig, found)
+			if err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+}
+
 func init() {
-	discoverCmd.Flags().IntSliceVarP(&ports, "ports", "p", []int{22}, "ports to check during initial discovery")
-	discoverCmd.Flags().StringSliceVarP(&exclude, "exclude", "x", []string{}, "subnets to exclude from discovery")
+	discoverCmd.Flags().StringSliceVarP(&ports, "ports", "p", []string{"22"}, "ports or port ranges to check during initial discovery, like 22,2200-2299")
+	discoverCmd.Flags().StringSliceVarP(&exclude, "exclude", "x", []string{}, "hosts, subnets or ranges to exclude from discovery")
 	discoverCmd.Flags().IntVar(&timeoutDiscoverMs, "timeout", 4000, "SSH connection timeout in milliseconds")
+	discoverCmd.Flags().StringSliceVar(&seedFiles, "seed-file", []string{}, "add the IPv6 addresses from a neighbour table ('ip -6 neigh') or hosts file")
+	discoverCmd.Flags().BoolVar(&allowLargeIPv6, "allow-large-ipv6", false, "allow enumerating IPv6 ranges larger than a /112")
 
-	discoverFromFileCmd.Flags().IntSliceVarP(&ports, "ports", "p", []int{22}, "ports to check during initial discovery")
+	discoverFromFileCmd.Flags().StringSliceVarP(&ports, "ports", "p", []string{"22"}, "ports or port ranges to check during initial discovery, like 22,2200-2299")
+	discoverFromFileCmd.Flags().StringSliceVarP(&exclude, "exclude", "x", []string{}, "hosts, subnets or ranges to exclude from discovery")
 	discoverFromFileCmd.Flags().IntVar(&timeoutDiscoverMs, "timeout", 4000, "SSH connection timeout in milliseconds")
+	discoverFromFileCmd.Flags().StringSliceVar(&seedFiles, "seed-file", []string{}, "add the IPv6 addresses from a neighbour table ('ip -6 neigh') or hosts file")
+	discoverFromFileCmd.Flags().BoolVar(&allowLargeIPv6, "allow-large-ipv6", false, "allow enumerating IPv6 ranges larger than a /112")
 	RootCmd.AddCommand(discoverCmd)
 	discoverCmd.AddCommand(discoverFromFileCmd)
+
+	discoverCmd.AddCommand(discoverImportCmd)
+	for _, c := range []*cobra.Command{
+		importDiscovery("nmap", "import nmap -p 22,2222 sweep.xml", sshauditor.ParseNmapXML),
+		importDiscovery("masscan", "import masscan masscan.json", sshauditor.ParseMasscan),
+	} {
+		c.Flags().StringSliceVarP(&ports, "ports", "p", []string{"22"}, "open ports to treat as ssh when the scanner didn't identify the service")
+		c.Flags().StringSliceVarP(&exclude, "exclude", "x", []string{}, "hosts, subnets or ranges to skip")
+		discoverImportCmd.AddCommand(c)
+	}
 }
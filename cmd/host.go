@@ -51,9 +51,51 @@ var hostDeleteCmd = &cobra.Command{
 	},
 }
 
+var hostClearRateLimitCmd = &cobra.Command{
+	Use:     "clear-rate-limit",
+	Example: "clear-rate-limit 10.0.0.1:22",
+	Short:   "let scans make as many connections to hosts as they need again",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, host := range args {
+			err := store.ClearHostRateLimit(host)
+			if err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var hostErrorsUntested bool
+
+var hostErrorsCmd = &cobra.Command{
+	Use:     "errors",
+	Aliases: []string{"e"},
+	Short:   "list hosts whose credentials fail with connection errors, were deferred or were never tested",
+	Run: func(cmd *cobra.Command, args []string) {
+		hosts, err := store.GetHostErrors()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		w := json.NewEncoder(os.Stdout)
+		for _, h := range hosts {
+			if hostErrorsUntested && h.Tested != 0 {
+				continue
+			}
+			if err := w.Encode(h); err != nil {
+				panic(err)
+			}
+		}
+	},
+}
+
 func init() {
 	RootCmd.AddCommand(hostCmd)
 	hostCmd.AddCommand(hostListCmd)
 	hostListCmd.Flags().IntVar(&hostMaxAgeDays, "max-age-days", 14, "List hosts seen at most this many days ago")
 	hostCmd.AddCommand(hostDeleteCmd)
+	hostCmd.AddCommand(hostClearRateLimitCmd)
+	hostCmd.AddCommand(hostErrorsCmd)
+	hostErrorsCmd.Flags().BoolVar(&hostErrorsUntested, "untested", false, "Only list hosts none of whose queued credentials were tested yet")
 }
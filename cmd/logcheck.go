@@ -28,7 +28,7 @@ var logcheckRunCmd = &cobra.Command{
 			Concurrency: concurrency,
 			Timeout: timeoutDuration,
 		}
-		err := auditor.Logcheck(scanConfig)
+		err := auditor.Logcheck(interruptContext(), scanConfig)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
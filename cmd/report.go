@@ -7,7 +7,6 @@ import (
 	text_template "text/template"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/hodor/ssh-auditor/sshauditor"
 
 	"github.com/spf13/cobra"
 )
@@ -22,7 +21,7 @@ var reportJSONCmd = &cobra.Command{
 	Use:   "json",
 	Short: "json report",
 	Run: func(cmd *cobra.Command, args []string) {
-		auditor := sshauditor.New(store)
+		auditor := newReportAuditor()
 		report, err := auditor.GetReport()
 		if err != nil {
 			log.Error(err.Error())
@@ -42,7 +41,7 @@ var reportTXTCmd = &cobra.Command{
 	Use:   "txt",
 	Short: "plain text report",
 	Run: func(cmd *cobra.Command, args []string) {
-		auditor := sshauditor.New(store)
+		auditor := newReportAuditor()
 		report, err := auditor.GetReport()
 		if err != nil {
 			log.Error(err.Error())
@@ -61,7 +60,7 @@ var reportHTMLCmd = &cobra.Command{
 	Use:   "html",
 	Short: "html report",
 	Run: func(cmd *cobra.Command, args []string) {
-		auditor := sshauditor.New(store)
+		auditor := newReportAuditor()
 		report, err := auditor.GetReport()
 		if err != nil {
 			log.Error(err.Error())
@@ -78,6 +77,8 @@ var reportHTMLCmd = &cobra.Command{
 }
 
 func init() {
+	reportCmd.PersistentFlags().StringVar(&vulnFeedFile, "vuln-feed", "", "JSON file of vulnerable ssh server versions to match hosts against, like feeds/ssh-vulns.json")
+	reportCmd.PersistentFlags().StringVar(&algorithmPolicyFile, "algorithm-policy", "", "JSON file of rules deciding which algorithms are weak, replacing the built-in policy")
 	RootCmd.AddCommand(reportCmd)
 	reportCmd.AddCommand(reportJSONCmd)
 	reportCmd.AddCommand(reportTXTCmd)
@@ -88,11 +89,65 @@ var reportTXTTemplate = `
 Vulnerabilities: {{ .VulnerabilitiesCount }} 
 {{range .Vulnerabilities}}
 	Host {{.Host.Hostport}}
+	Names {{.Host.Names}}
+	PTR {{.Host.PTRNames}}
 	Version {{.Host.Version}}
 	User {{.HostCredential.User}}
 	Password {{.HostCredential.Password}}
 	Result {{.HostCredential.Result}}
 	Last Tested {{.HostCredential.LastTested}}
+	State {{.HostCredential.State}}
+{{- with .HostCredential.Prompts}}
+	Prompts {{.}}
+{{- end}}
+	First Detected {{.HostCredential.FirstDetected}}
+	Last Confirmed {{.HostCredential.LastConfirmed}}
+{{end}}
+
+Fixed Vulnerabilities: {{ .FixedVulnerabilitiesCount }}
+{{range .FixedVulnerabilities}}
+	Host {{.Host.Hostport}}
+	Names {{.Host.Names}}
+	PTR {{.Host.PTRNames}}
+	Version {{.Host.Version}}
+	User {{.HostCredential.User}}
+	Password {{.HostCredential.Password}}
+	Result {{.HostCredential.ConfirmedResult}}
+	First Detected {{.HostCredential.FirstDetected}}
+	Last Confirmed {{.HostCredential.LastConfirmed}}
+	Remediated {{.HostCredential.RemediatedAt}}
+	Time To Remediate {{.HostCredential.TimeToRemediate}}
+{{end}}
+
+Software Vulnerabilities: {{ .SoftwareVulnerabilitiesCount }}
+{{range .SoftwareVulnerabilities}}
+	Host {{.Host.Hostport}}
+	Names {{.Host.Names}}
+	Software {{.Software}}
+	ID {{.ID}}
+	Severity {{.Severity}}
+	Summary {{.Summary}}
+{{end}}
+
+Host Findings: {{ .FindingsCount }}
+{{range .Findings}}
+	Host {{.Host.Hostport}}
+	Names {{.Host.Names}}
+	Version {{.Host.Version}}
+	Finding {{.HostFinding.Type}}
+	Detail {{.HostFinding.Detail}}
+	First Found {{.HostFinding.FirstFound}}
+	Last Found {{.HostFinding.LastFound}}
+{{end}}
+
+Weak Algorithms: {{ .WeakAlgorithmsCount }}
+{{range .WeakAlgorithms}}
+	Host {{.Host.Hostport}}
+	Names {{.Host.Names}}
+	Version {{.Host.Version}}
+	Type {{.Type}}
+	Algorithm {{.Algorithm}}
+	Reason {{.Reason}}
 {{end}}
 
 Duplicate Keys: {{ .DuplicateKeysCount }} 
@@ -101,6 +156,7 @@ Duplicate Keys: {{ .DuplicateKeysCount }}
 {{ range $hosts }}
 	Host {{.Hostport}}
 	Version {{.Version}}
+	Software {{.Software}}
 	Seen First {{.SeenFirst}}
 	Seen Last {{.SeenLast}}
 {{end}}
@@ -109,9 +165,16 @@ Duplicate Keys: {{ .DuplicateKeysCount }}
 Active Hosts: {{ .ActiveHostsCount }}
 {{ range .ActiveHosts }}
 	Host {{.Hostport}}
+	Names {{.Names}}
+	PTR {{.PTRNames}}
 	Version {{.Version}}
+	Software {{.Software}}
 	Seen First {{.SeenFirst}}
 	Seen Last {{.SeenLast}}
+	Auth Methods {{.AuthMethods}}
+{{- range .Keys }}
+	Key {{.Type}} {{.Bits}} {{.Fingerprint}}
+{{- end}}
 {{end}}
 `
 
@@ -124,22 +187,150 @@ var reportHTMLTemplate = `
 <thead>
 	<tr>
 		<th>Host</th>
+		<th>Names</th>
+		<th>PTR</th>
 		<th>User</th>
 		<th>Password</th>
 		<th>Result</th>
 		<th>Last Tested</th>
 		<th>Version</th>
+		<th>State</th>
+		<th>First Detected</th>
+		<th>Last Confirmed</th>
+		<th>Prompts</th>
 	</tr>
 </thead>
 <tbody>
 {{range .Vulnerabilities}}
 <tr>
 	<td> {{.Host.Hostport}} </td>
+	<td> {{.Host.Names}} </td>
+	<td> {{.Host.PTRNames}} </td>
 	<td> {{.HostCredential.User}} </td>
 	<td> {{.HostCredential.Password}} </td>
 	<td> {{.HostCredential.Result}} </td>
 	<td> {{.HostCredential.LastTested}} </td>
 	<td> {{.Host.Version}} </td>
+	<td> {{.HostCredential.State}} </td>
+	<td> {{.HostCredential.FirstDetected}} </td>
+	<td> {{.HostCredential.LastConfirmed}} </td>
+	<td> {{.HostCredential.Prompts}} </td>
+</tr>
+{{end}}
+</tbody>
+</table>
+
+<h1>Fixed Vulnerabilities: {{ .FixedVulnerabilitiesCount }}</h1>
+<table>
+<thead>
+	<tr>
+		<th>Host</th>
+		<th>Names</th>
+		<th>PTR</th>
+		<th>User</th>
+		<th>Password</th>
+		<th>Result</th>
+		<th>Version</th>
+		<th>First Detected</th>
+		<th>Last Confirmed</th>
+		<th>Remediated</th>
+		<th>Time To Remediate</th>
+	</tr>
+</thead>
+<tbody>
+{{range .FixedVulnerabilities}}
+<tr>
+	<td> {{.Host.Hostport}} </td>
+	<td> {{.Host.Names}} </td>
+	<td> {{.Host.PTRNames}} </td>
+	<td> {{.HostCredential.User}} </td>
+	<td> {{.HostCredential.Password}} </td>
+	<td> {{.HostCredential.ConfirmedResult}} </td>
+	<td> {{.Host.Version}} </td>
+	<td> {{.HostCredential.FirstDetected}} </td>
+	<td> {{.HostCredential.LastConfirmed}} </td>
+	<td> {{.HostCredential.RemediatedAt}} </td>
+	<td> {{.HostCredential.TimeToRemediate}} </td>
+</tr>
+{{end}}
+</tbody>
+</table>
+
+<h1>Software Vulnerabilities: {{ .SoftwareVulnerabilitiesCount }}</h1>
+<table>
+<thead>
+	<tr>
+		<th>Host</th>
+		<th>Names</th>
+		<th>Software</th>
+		<th>ID</th>
+		<th>Severity</th>
+		<th>Summary</th>
+	</tr>
+</thead>
+<tbody>
+{{range .SoftwareVulnerabilities}}
+<tr>
+	<td> {{.Host.Hostport}} </td>
+	<td> {{.Host.Names}} </td>
+	<td> {{.Software}} </td>
+	<td> {{.ID}} </td>
+	<td> {{.Severity}} </td>
+	<td> {{.Summary}} </td>
+</tr>
+{{end}}
+</tbody>
+</table>
+
+<h1>Host Findings: {{ .FindingsCount }}</h1>
+<table>
+<thead>
+	<tr>
+		<th>Host</th>
+		<th>Names</th>
+		<th>Version</th>
+		<th>Finding</th>
+		<th>Detail</th>
+		<th>First Found</th>
+		<th>Last Found</th>
+	</tr>
+</thead>
+<tbody>
+{{range .Findings}}
+<tr>
+	<td> {{.Host.Hostport}} </td>
+	<td> {{.Host.Names}} </td>
+	<td> {{.Host.Version}} </td>
+	<td> {{.HostFinding.Type}} </td>
+	<td> {{.HostFinding.Detail}} </td>
+	<td> {{.HostFinding.FirstFound}} </td>
+	<td> {{.HostFinding.LastFound}} </td>
+</tr>
+{{end}}
+</tbody>
+</table>
+
+<h1>Weak Algorithms: {{ .WeakAlgorithmsCount }}</h1>
+<table>
+<thead>
+	<tr>
+		<th>Host</th>
+		<th>Names</th>
+		<th>Version</th>
+		<th>Type</th>
+		<th>Algorithm</th>
+		<th>Reason</th>
+	</tr>
+</thead>
+<tbody>
+{{range .WeakAlgorithms}}
+<tr>
+	<td> {{.Host.Hostport}} </td>
+	<td> {{.Host.Names}} </td>
+	<td> {{.Host.Version}} </td>
+	<td> {{.Type}} </td>
+	<td> {{.Algorithm}} </td>
+	<td> {{.Reason}} </td>
 </tr>
 {{end}}
 </tbody>
@@ -153,6 +344,8 @@ var reportHTMLTemplate = `
 	<tr>
 		<th>Host</th>
 		<th>Version</th>
+		<th>Software</th>
+		<th>Distro</th>
 		<th>Seen First</th>
 		<th>Seen Last</th>
 	</tr>
@@ -162,6 +355,8 @@ var reportHTMLTemplate = `
 <tr>
 	<td> {{.Hostport}} </td>
 	<td> {{.Version}} </td>
+	<td> {{.Software}} </td>
+	<td> {{.Software.Distro}} </td>
 	<td> {{.SeenFirst}} </td>
 	<td> {{.SeenLast}} </td>
 </tr>
@@ -175,18 +370,30 @@ var reportHTMLTemplate = `
 <thead>
 	<tr>
 		<th>Host</th>
+		<th>Names</th>
+		<th>PTR</th>
 		<th>Version</th>
+		<th>Software</th>
+		<th>Distro</th>
 		<th>Seen First</th>
 		<th>Seen Last</th>
+		<th>Auth Methods</th>
+		<th>Keys</th>
 	</tr>
 </thead>
 <tbody>
 {{ range .ActiveHosts }}
 <tr>
 	<td> {{.Hostport}} </td>
+	<td> {{.Names}} </td>
+	<td> {{.PTRNames}} </td>
 	<td> {{.Version}} </td>
+	<td> {{.Software}} </td>
+	<td> {{.Software.Distro}} </td>
 	<td> {{.SeenFirst}} </td>
 	<td> {{.SeenLast}} </td>
+	<td> {{.AuthMethods}} </td>
+	<td> {{range .Keys}}{{.Type}} {{.Bits}} {{.Fingerprint}}<br> {{end}}</td>
 </tr>
 {{end}}
 </tbody>
@@ -9,6 +9,12 @@ import (
 )
 
 var timeoutRescanMs int
+var rescanInsecureIgnoreHostKeys bool
+var rescanResume bool
+var rescanMaxHostErrors int
+var rescanRetries int
+var rescanRetryBackoffMs int
+var rescanPasswordsPerConnection int
 
 var rescanCmd = &cobra.Command{
 	Use:   "rescan",
@@ -19,9 +25,15 @@ var rescanCmd = &cobra.Command{
 		scanConfig := sshauditor.ScanConfiguration{
 			Concurrency: concurrency,
 			Timeout: timeoutDuration,
+			InsecureIgnoreHostKeys: rescanInsecureIgnoreHostKeys,
+			Resume: rescanResume,
+			MaxHostErrors: rescanMaxHostErrors,
+			Retries: rescanRetries,
+			RetryBackoff: time.Duration(rescanRetryBackoffMs) * time.Millisecond,
+			PasswordsPerConnection: rescanPasswordsPerConnection,
 		}
 		auditor := sshauditor.New(store)
-		_, err := auditor.Rescan(scanConfig)
+		_, err := auditor.Rescan(interruptContext(), scanConfig)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
@@ -31,5 +43,11 @@ var rescanCmd = &cobra.Command{
 
 func init() {
 	rescanCmd.Flags().IntVar(&timeoutRescanMs, "timeout", 4000, "SSH connection timeout in milliseconds")
+	rescanCmd.Flags().BoolVar(&rescanInsecureIgnoreHostKeys, "insecure-ignore-host-keys", false, "Send credentials to hosts presenting a key that wasn't seen during discovery")
+	rescanCmd.Flags().BoolVar(&rescanResume, "resume", false, "Continue the last interrupted rescan where it stopped, if there is one")
+	rescanCmd.Flags().IntVar(&rescanMaxHostErrors, "max-host-errors", sshauditor.DefaultMaxHostErrors, "Failed connections to a host before its remaining credentials are deferred to the next run")
+	rescanCmd.Flags().IntVar(&rescanRetries, "retries", 0, "How often to retry a connection failing with a timeout or reset")
+	rescanCmd.Flags().IntVar(&rescanRetryBackoffMs, "retry-backoff", int(sshauditor.DefaultRetryBackoff/time.Millisecond), "Delay before the first retry in milliseconds, doubled for each further retry")
+	rescanCmd.Flags().IntVar(&rescanPasswordsPerConnection, "passwords-per-connection", 1, "Passwords for the same user to try over one connection, fewer if the server disconnects earlier")
 	RootCmd.AddCommand(rescanCmd)
 }
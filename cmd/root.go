@@ -1,32 +1,105 @@
 package cmd
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
-	log "github.com/inconshreveable/log15"
 	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
 	"github.com/spf13/cobra"
 )
 
-var store *sshauditor.SQLiteStore
+var store sshauditor.Store
 var dbPath string
 var debug bool
 var concurrency int
+var passphraseFile string
 
-func initStore() error {
-	//This should really return err, but it doesn't look as nice as when I fail immediately
-	//cobra gives the help for the current command, which is irrelevant
-	s, err := sshauditor.NewSQLiteStore(dbPath)
+//readPassphrase returns the contents of file if set, otherwise the value of
+//the env environment variable.  A trailing newline is ignored.
+func readPassphrase(file, env string) ([]byte, error) {
+	if file == "" {
+		return []byte(os.Getenv(env)), nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(b), "\r\n")), nil
+}
+
+//unlockStore unlocks an encrypted store using the configured passphrase
+func unlockStore() {
+	passphrase, err := readPassphrase(passphraseFile, "SSH_AUDITOR_PASSPHRASE")
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if len(passphrase) == 0 {
+		return
+	}
+	err = store.Unlock(passphrase)
+	if err == sshauditor.ErrNotEncrypted {
+		log.Warn("passphrase given but the database is not encrypted, see 'db encrypt'")
+		return
+	}
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
-	err = s.Init()
+}
+
+//interruptContext returns a context that is canceled on SIGINT or SIGTERM,
+//so that a run can stop gracefully.  A second signal exits immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Warn("stopping after the attempts in progress, signal again to exit immediately", "signal", sig.String())
+		signal.Stop(sigs)
+		cancel()
+	}()
+	return ctx
+}
+
+func initLogging() {
+	if debug {
+		log.Root().SetHandler(log.LvlFilterHandler(
+			log.LvlDebug,
+			log.StderrHandler))
+	} else {
+		log.Root().SetHandler(log.LvlFilterHandler(
+			log.LvlInfo,
+			log.StderrHandler))
+	}
+}
+
+//openStore opens the store without creating or migrating the schema
+func openStore() {
+	s, err := sshauditor.NewStore(dbPath)
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
 	store = s
+}
+
+func initStore() error {
+	//This should really return err, but it doesn't look as nice as when I fail immediately
+	//cobra gives the help for the current command, which is irrelevant
+	openStore()
+	err := store.Init()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	unlockStore()
 	return err
 }
 
@@ -35,21 +108,14 @@ var RootCmd = &cobra.Command{
 	Short: "ssh-auditor tests ssh server password security",
 	Long:  `Complete documentation is available at https://github.com/ncsa/ssh-auditor`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		if debug {
-			log.Root().SetHandler(log.LvlFilterHandler(
-				log.LvlDebug,
-				log.StderrHandler))
-		} else {
-			log.Root().SetHandler(log.LvlFilterHandler(
-				log.LvlInfo,
-				log.StderrHandler))
-		}
+		initLogging()
 		return initStore()
 	},
 }
 
 func init() {
 	RootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 256, "Number of concurrent hosts to scan at once")
-	RootCmd.PersistentFlags().StringVar(&dbPath, "db", "ssh_db.sqlite", "Path to database file")
+	RootCmd.PersistentFlags().StringVar(&dbPath, "db", "ssh_db.sqlite", "Path to SQLite database file or postgres:// URI")
 	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "debug")
+	RootCmd.PersistentFlags().StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase for an encrypted database, defaults to $SSH_AUDITOR_PASSPHRASE")
 }
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+)
+
+var runsCmd = &cobra.Command{
+	Use:     "runs",
+	Short:   "show past discover, scan, rescan and logcheck runs",
+	Aliases: []string{"run"},
+}
+
+var runsLimit int
+
+var runsListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"l"},
+	Short:   "list recent runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := store.GetScanRuns(runsLimit)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		w := json.NewEncoder(os.Stdout)
+		for _, r := range runs {
+			if err := w.Encode(r); err != nil {
+				panic(err)
+			}
+		}
+	},
+}
+
+var runsShowCmd = &cobra.Command{
+	Use:     "show",
+	Example: "show 42",
+	Short:   "show a run along with the host changes and vulnerabilities it found",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			log.Error("invalid run id", "id", args[0])
+			os.Exit(1)
+		}
+		run, err := store.GetScanRun(id)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		changes, err := store.GetScanRunChanges(id)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		vulns, err := store.GetScanRunVulnerabilities(id)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		w := json.NewEncoder(os.Stdout)
+		w.SetIndent("", "  ")
+		err = w.Encode(struct {
+			Run             sshauditor.ScanRun
+			HostChanges     []sshauditor.HostChange
+			Vulnerabilities []sshauditor.Vulnerability
+		}{run, changes, vulns})
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runsCmd)
+	runsListCmd.Flags().IntVar(&runsLimit, "limit", 20, "Number of runs to list, newest first")
+	runsCmd.AddCommand(runsListCmd)
+	runsCmd.AddCommand(runsShowCmd)
+}
@@ -9,6 +9,12 @@ import (
 )
 
 var timeoutScanMs int
+var scanInsecureIgnoreHostKeys bool
+var scanResume bool
+var scanMaxHostErrors int
+var scanRetries int
+var scanRetryBackoffMs int
+var scanPasswordsPerConnection int
 
 var scanCmd = &cobra.Command{
 	Use:   "scan",
@@ -20,9 +26,15 @@ var scanCmd = &cobra.Command{
 		scanConfig := sshauditor.ScanConfiguration{
 			Concurrency: concurrency,
 			Timeout: timeoutDuration,
+			InsecureIgnoreHostKeys: scanInsecureIgnoreHostKeys,
+			Resume: scanResume,
+			MaxHostErrors: scanMaxHostErrors,
+			Retries: scanRetries,
+			RetryBackoff: time.Duration(scanRetryBackoffMs) * time.Millisecond,
+			PasswordsPerConnection: scanPasswordsPerConnection,
 		}
 		auditor := sshauditor.New(store)
-		_, err := auditor.Scan(scanConfig)
+		_, err := auditor.Scan(interruptContext(), scanConfig)
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
@@ -45,6 +57,12 @@ var scanResetIntervalCmd = &cobra.Command{
 
 func init() {
 	scanCmd.Flags().IntVar(&timeoutScanMs, "timeout", 4000, "SSH connection timeout in milliseconds")
+	scanCmd.Flags().BoolVar(&scanInsecureIgnoreHostKeys, "insecure-ignore-host-keys", false, "Send credentials to hosts presenting a key that wasn't seen during discovery")
+	scanCmd.Flags().BoolVar(&scanResume, "resume", false, "Continue the last interrupted scan where it stopped, if there is one")
+	scanCmd.Flags().IntVar(&scanMaxHostErrors, "max-host-errors", sshauditor.DefaultMaxHostErrors, "Failed connections to a host before its remaining credentials are deferred to the next run")
+	scanCmd.Flags().IntVar(&scanRetries, "retries", 0, "How often to retry a connection failing with a timeout or reset")
+	scanCmd.Flags().IntVar(&scanRetryBackoffMs, "retry-backoff", int(sshauditor.DefaultRetryBackoff/time.Millisecond), "Delay before the first retry in milliseconds, doubled for each further retry")
+	scanCmd.Flags().IntVar(&scanPasswordsPerConnection, "passwords-per-connection", 1, "Passwords for the same user to try over one connection, fewer if the server disconnects earlier")
 	RootCmd.AddCommand(scanCmd)
 	scanCmd.AddCommand(scanResetIntervalCmd)
 }
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	html_template "html/template"
+	"os"
+	"strconv"
+	"strings"
+	text_template "text/template"
+
+	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
+	"github.com/spf13/cobra"
+)
+
+var versionsMaxAgeDays int
+
+var reportVersionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "inventory of ssh server versions run by active hosts",
+}
+
+func getVersionInventory() []sshauditor.VersionGroup {
+	auditor := sshauditor.New(store)
+	groups, err := auditor.VersionInventory(versionsMaxAgeDays)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	return groups
+}
+
+var reportVersionsJSONCmd = &cobra.Command{
+	Use:   "json",
+	Short: "json version inventory",
+	Run: func(cmd *cobra.Command, args []string) {
+		w := json.NewEncoder(os.Stdout)
+		w.SetIndent("", "  ")
+		err := w.Encode(getVersionInventory())
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var reportVersionsTXTCmd = &cobra.Command{
+	Use:   "txt",
+	Short: "plain text version inventory",
+	Run: func(cmd *cobra.Command, args []string) {
+		t := text_template.Must(text_template.New("versions").Parse(versionsTXTTemplate))
+		err := t.Execute(os.Stdout, getVersionInventory())
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var reportVersionsHTMLCmd = &cobra.Command{
+	Use:   "html",
+	Short: "html version inventory",
+	Run: func(cmd *cobra.Command, args []string) {
+		t := html_template.Must(html_template.New("versions").Parse(versionsHTMLTemplate))
+		err := t.Execute(os.Stdout, getVersionInventory())
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var reportVersionsCSVCmd = &cobra.Command{
+	Use:   "csv",
+	Short: "csv version inventory, one line per version with the hosts space separated",
+	Run: func(cmd *cobra.Command, args []string) {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"product", "version", "distro", "release", "status", "status_reason", "end_of_life", "count", "seen_first", "seen_last", "hosts"})
+		for _, g := range getVersionInventory() {
+			var hosts []string
+			for _, h := range g.Hosts {
+				hosts = append(hosts, h.Hostport)
+			}
+			w.Write([]string{g.Product, g.Version, g.Distro, g.Release, g.Status, g.StatusReason, g.EndOfLife,
+				strconv.Itoa(g.Count), g.SeenFirst, g.SeenLast, strings.Join(hosts, " ")})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	reportCmd.AddCommand(reportVersionsCmd)
+	reportVersionsCmd.PersistentFlags().IntVar(&versionsMaxAgeDays, "max-age-days", 14, "Include hosts seen at most this many days ago")
+	reportVersionsCmd.AddCommand(reportVersionsJSONCmd)
+	reportVersionsCmd.AddCommand(reportVersionsTXTCmd)
+	reportVersionsCmd.AddCommand(reportVersionsHTMLCmd)
+	reportVersionsCmd.AddCommand(reportVersionsCSVCmd)
+}
+
+var versionsTXTTemplate = `
+{{- range . }}
+{{.Product}} {{.Version}} {{.Distro}}: {{.Count}}
+	Release {{or .Release "unknown"}}
+	Status {{.Status}}{{with .StatusReason}} ({{.}}){{end}}
+	End Of Life {{or .EndOfLife "unknown"}}
+	Seen First {{.SeenFirst}}
+	Seen Last {{.SeenLast}}
+{{- range .Hosts }}
+	Host {{.Hostport}}{{with .Names}} {{.}}{{end}}
+{{- end}}
+{{end}}
+`
+
+var versionsHTMLTemplate = `
+<html>
+<body>
+
+<h1>SSH Versions</h1>
+<table>
+<thead>
+	<tr>
+		<th>Product</th>
+		<th>Version</th>
+		<th>Distro</th>
+		<th>Release</th>
+		<th>Status</th>
+		<th>End Of Life</th>
+		<th>Count</th>
+		<th>Seen First</th>
+		<th>Seen Last</th>
+		<th>Hosts</th>
+	</tr>
+</thead>
+<tbody>
+{{range .}}
+<tr>
+	<td>{{.Product}}</td>
+	<td>{{.Version}}</td>
+	<td>{{.Distro}}</td>
+	<td>{{or .Release "unknown"}}</td>
+	<td>{{.Status}}{{with .StatusReason}} ({{.}}){{end}}</td>
+	<td>{{or .EndOfLife "unknown"}}</td>
+	<td>{{.Count}}</td>
+	<td>{{.SeenFirst}}</td>
+	<td>{{.SeenLast}}</td>
+	<td>{{range .Hosts}}{{.Hostport}}<br>{{end}}</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+
+</body>
+</html>
+`
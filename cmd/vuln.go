@@ -4,34 +4,140 @@ import (
 	"fmt"
 	"os"
 
-	log "github.com/inconshreveable/log15"
 	"github.com/hodor/ssh-auditor/sshauditor"
+	log "github.com/inconshreveable/log15"
 	"github.com/spf13/cobra"
 )
 
+var vulnAll bool
+var vulnAlgorithms bool
+var algorithmPolicyFile string
+var vulnFeedFile string
+
+//openOrExit opens file, exiting if it can't be read
+func openOrExit(file string) *os.File {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	return f
+}
+
+//newReportAuditor returns an auditor using the policy in the
+//--algorithm-policy file, or the default policy if it isn't given, and the
+//--vuln-feed vulnerability feed
+func newReportAuditor() *sshauditor.SSHAuditor {
+	auditor := sshauditor.New(store)
+	if algorithmPolicyFile != "" {
+		f := openOrExit(algorithmPolicyFile)
+		defer f.Close()
+		policy, err := sshauditor.LoadAlgorithmPolicy(f)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		auditor.SetAlgorithmPolicy(policy)
+	}
+	if vulnFeedFile != "" {
+		f := openOrExit(vulnFeedFile)
+		defer f.Close()
+		feed, err := sshauditor.LoadVulnFeed(f)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		auditor.SetVulnFeed(feed)
+	}
+	return auditor
+}
+
 var vulnCmd = &cobra.Command{
 	Use:   "vuln",
 	Short: "Show vulnerabilities",
 	Run: func(cmd *cobra.Command, args []string) {
-		auditor := sshauditor.New(store)
+		auditor := newReportAuditor()
 		vulns, err := auditor.Vulnerabilities()
 		if err != nil {
 			log.Error(err.Error())
 			os.Exit(1)
 		}
+		if vulnAll {
+			fixed, err := auditor.FixedVulnerabilities()
+			if err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
+			}
+			vulns = append(vulns, fixed...)
+		}
 		for _, v := range vulns {
-			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n",
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 				v.Host.Hostport,
 				v.HostCredential.User,
 				v.HostCredential.Password,
-				v.HostCredential.Result,
+				v.HostCredential.ConfirmedResult,
 				v.HostCredential.LastTested,
 				v.Host.Version,
+				v.HostCredential.State,
+				v.HostCredential.FirstDetected,
+				v.HostCredential.LastConfirmed,
+				v.HostCredential.RemediatedAt,
+				v.Host.Names,
+				v.Host.PTRNames,
+			)
+		}
+		if !vulnAlgorithms {
+			return
+		}
+		weak, err := auditor.WeakAlgorithms()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		for _, f := range weak {
+			fmt.Printf("%s\tweak %s\t%s\t%s\t%s\t%s\t%s\n",
+				f.Host.Hostport,
+				f.Type,
+				f.Algorithm,
+				f.Reason,
+				f.Host.Version,
+				f.Host.Names,
+				f.Host.PTRNames,
+			)
+		}
+	},
+}
+
+var vulnSoftwareCmd = &cobra.Command{
+	Use:   "software",
+	Short: "Show vulnerable ssh server software from the --vuln-feed",
+	Run: func(cmd *cobra.Command, args []string) {
+		auditor := newReportAuditor()
+		software, err := auditor.SoftwareVulnerabilities()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		for _, f := range software {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				f.Host.Hostport,
+				f.ID,
+				f.Severity,
+				f.Software,
+				f.Summary,
+				f.Host.Version,
+				f.Host.Names,
+				f.Host.PTRNames,
 			)
 		}
 	},
 }
 
 func init() {
+	vulnCmd.Flags().BoolVar(&vulnAll, "all", false, "also show vulnerabilities that have been fixed")
+	vulnCmd.Flags().BoolVar(&vulnAlgorithms, "algorithms", false, "also show weak kex, host key, cipher and mac algorithms offered by hosts")
+	vulnCmd.PersistentFlags().StringVar(&vulnFeedFile, "vuln-feed", "", "JSON file of vulnerable ssh server versions to match hosts against, like feeds/ssh-vulns.json")
+	vulnCmd.Flags().StringVar(&algorithmPolicyFile, "algorithm-policy", "", "JSON file of rules deciding which algorithms are weak, replacing the built-in policy")
+	vulnCmd.AddCommand(vulnSoftwareCmd)
 	RootCmd.AddCommand(vulnCmd)
 }
@@ -5,11 +5,12 @@ go 1.13
 require (
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/pkg/errors v0.9.1
 	github.com/sebkl/splunk-golang v0.0.0-20151111121930-5ea88f4c7e42
 	github.com/spf13/cobra v0.0.6
-	golang.org/x/crypto v0.0.0-20200219234226-1ad67e1f0ef4
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 )
@@ -0,0 +1,268 @@
+package sshauditor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//SSHAlgorithms are the algorithms a server offers in its KEXINIT, in the
+//server's order of preference.  Ciphers and MACs combine both directions.
+type SSHAlgorithms struct {
+	Kex     []string
+	HostKey []string
+	Ciphers []string
+	MACs    []string
+}
+
+//Algorithm types used by AlgorithmRule and AlgorithmFinding
+const (
+	AlgoKex     = "kex"
+	AlgoHostKey = "hostkey"
+	AlgoCipher  = "cipher"
+	AlgoMAC     = "mac"
+)
+
+//byType returns the offered algorithms of the given type
+func (a SSHAlgorithms) byType(algoType string) []string {
+	switch algoType {
+	case AlgoKex:
+		return a.Kex
+	case AlgoHostKey:
+		return a.HostKey
+	case AlgoCipher:
+		return a.Ciphers
+	case AlgoMAC:
+		return a.MACs
+	}
+	return nil
+}
+
+const (
+	msgKexInit = 20
+	//maxPacket is the largest packet RFC 4253 requires implementations
+	//to accept
+	maxPacket = 35000
+)
+
+//readPacket reads an unencrypted binary packet and returns its payload
+func readPacket(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	padding := uint32(header[4])
+	if length > maxPacket || length < padding+1 {
+		return nil, errors.Errorf("invalid packet length %d", length)
+	}
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	return rest[:length-1-padding], nil
+}
+
+//parseKexInit parses the name-lists of a KEXINIT payload
+func parseKexInit(payload []byte) (SSHAlgorithms, error) {
+	var algos SSHAlgorithms
+	//Message type followed by a 16 byte cookie
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return algos, errors.New("not a KEXINIT message")
+	}
+	b := payload[17:]
+	var lists [10][]string
+	for i := range lists {
+		if len(b) < 4 {
+			return algos, errors.New("truncated KEXINIT message")
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return algos, errors.New("truncated KEXINIT message")
+		}
+		if n > 0 {
+			lists[i] = strings.Split(string(b[:n]), ",")
+		}
+		b = b[n:]
+	}
+	algos.Kex = lists[0]
+	algos.HostKey = lists[1]
+	algos.Ciphers = mergeNames(lists[2], lists[3])
+	algos.MACs = mergeNames(lists[4], lists[5])
+	return algos, nil
+}
+
+//mergeNames returns the names in a followed by those only in b
+func mergeNames(a, b []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, n := range append(append([]string{}, a...), b...) {
+		if !seen[n] {
+			seen[n] = true
+			merged = append(merged, n)
+		}
+	}
+	return merged
+}
+
+//FetchSSHAlgorithms connects to hostport and returns the algorithms offered
+//in the server's KEXINIT.  The connection is closed before key exchange.
+func FetchSSHAlgorithms(hostport string, timeout time.Duration) (SSHAlgorithms, error) {
+	var algos SSHAlgorithms
+	conn, err := net.DialTimeout("tcp", hostport, timeout)
+	if err != nil {
+		return algos, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * timeout))
+	_, err = conn.Write([]byte("SSH-2.0-Go-ssh-auditor\r\n"))
+	if err != nil {
+		return algos, err
+	}
+	r := bufio.NewReader(conn)
+	//Servers may send other lines before the version, RFC 4253 4.2
+	for i := 0; ; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return algos, err
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			break
+		}
+		if i > 32 {
+			return algos, errors.New("no ssh version received")
+		}
+	}
+	payload, err := readPacket(r)
+	if err != nil {
+		return algos, err
+	}
+	return parseKexInit(payload)
+}
+
+//AlgorithmRule marks algorithms of a type that match Pattern as weak.
+//Pattern uses path.Match syntax, like *-cbc.
+type AlgorithmRule struct {
+	Type    string
+	Pattern string
+	Reason  string
+	//UnlessOffered, if set, only applies the rule to hosts that offer none
+	//of these algorithms of the same type
+	UnlessOffered []string `json:",omitempty"`
+}
+
+//AlgorithmPolicy is the list of rules deciding which offered algorithms
+//are weak
+type AlgorithmPolicy []AlgorithmRule
+
+//DefaultAlgorithmPolicy flags algorithms that are broken or deprecated by
+//RFC 8758, RFC 9142 and OpenSSH
+var DefaultAlgorithmPolicy = AlgorithmPolicy{
+	{Type: AlgoKex, Pattern: "diffie-hellman-group1-sha1", Reason: "1024 bit group with SHA-1"},
+	{Type: AlgoKex, Pattern: "diffie-hellman-group14-sha1", Reason: "SHA-1"},
+	{Type: AlgoKex, Pattern: "diffie-hellman-group-exchange-sha1", Reason: "SHA-1"},
+	{Type: AlgoKex, Pattern: "gss-*-sha1-*", Reason: "SHA-1"},
+	{Type: AlgoKex, Pattern: "rsa1024-sha1", Reason: "1024 bit RSA with SHA-1"},
+	{Type: AlgoHostKey, Pattern: "ssh-dss", Reason: "1024 bit DSA"},
+	{Type: AlgoHostKey, Pattern: "ssh-dss-cert-v01@openssh.com", Reason: "1024 bit DSA"},
+	{Type: AlgoHostKey, Pattern: "ssh-rsa", Reason: "only SHA-1 RSA signatures",
+		UnlessOffered: []string{"rsa-sha2-256", "rsa-sha2-512"}},
+	{Type: AlgoCipher, Pattern: "*-cbc", Reason: "CBC mode"},
+	{Type: AlgoCipher, Pattern: "*-cbc@*", Reason: "CBC mode"},
+	{Type: AlgoCipher, Pattern: "arcfour*", Reason: "RC4"},
+	{Type: AlgoCipher, Pattern: "des*", Reason: "DES"},
+	{Type: AlgoCipher, Pattern: "none", Reason: "no encryption"},
+	{Type: AlgoMAC, Pattern: "hmac-md5*", Reason: "MD5"},
+	{Type: AlgoMAC, Pattern: "*-96", Reason: "96 bit tag"},
+	{Type: AlgoMAC, Pattern: "*-96-etm@openssh.com", Reason: "96 bit tag"},
+	{Type: AlgoMAC, Pattern: "umac-64*", Reason: "64 bit tag"},
+	{Type: AlgoMAC, Pattern: "none", Reason: "no integrity protection"},
+}
+
+//LoadAlgorithmPolicy reads a policy from its JSON form, a list of rules
+func LoadAlgorithmPolicy(r io.Reader) (AlgorithmPolicy, error) {
+	var p AlgorithmPolicy
+	err := json.NewDecoder(r).Decode(&p)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadAlgorithmPolicy")
+	}
+	for _, rule := range p {
+		if !validAlgorithmType(rule.Type) {
+			return nil, fmt.Errorf("LoadAlgorithmPolicy: unknown algorithm type %q", rule.Type)
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("LoadAlgorithmPolicy: invalid pattern %q", rule.Pattern)
+		}
+	}
+	return p, nil
+}
+
+//validAlgorithmType returns true if t is one of the Algo types
+func validAlgorithmType(t string) bool {
+	return t == AlgoKex || t == AlgoHostKey || t == AlgoCipher || t == AlgoMAC
+}
+
+//AlgorithmFinding is a weak algorithm offered by a host
+type AlgorithmFinding struct {
+	Host      Host
+	Type      string
+	Algorithm string
+	Reason    string
+}
+
+//Check returns the findings for the algorithms offered by h.  Each offered
+//algorithm is reported at most once, for the first rule it matches.
+func (p AlgorithmPolicy) Check(h Host) []AlgorithmFinding {
+	var findings []AlgorithmFinding
+	algos := h.Algorithms()
+	for _, algoType := range []string{AlgoKex, AlgoHostKey, AlgoCipher, AlgoMAC} {
+		offered := algos.byType(algoType)
+		for _, algo := range offered {
+			if rule, weak := p.match(algoType, algo, offered); weak {
+				findings = append(findings, AlgorithmFinding{
+					Host:      h,
+					Type:      algoType,
+					Algorithm: algo,
+					Reason:    rule.Reason,
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func (p AlgorithmPolicy) match(algoType, algo string, offered []string) (AlgorithmRule, bool) {
+	for _, rule := range p {
+		if rule.Type != algoType {
+			continue
+		}
+		if ok, _ := path.Match(rule.Pattern, algo); !ok {
+			continue
+		}
+		if containsAny(offered, rule.UnlessOffered) {
+			continue
+		}
+		return rule, true
+	}
+	return AlgorithmRule{}, false
+}
+
+func containsAny(list, names []string) bool {
+	for _, l := range list {
+		for _, n := range names {
+			if l == n {
+				return true
+			}
+		}
+	}
+	return false
+}
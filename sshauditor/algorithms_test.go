@@ -0,0 +1,154 @@
+package sshauditor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//kexInitPayload builds a KEXINIT payload from its ten name-lists
+func kexInitPayload(lists ...string) []byte {
+	payload := append([]byte{msgKexInit}, make([]byte, 16)...)
+	for _, l := range lists {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(l)))
+		payload = append(payload, n[:]...)
+		payload = append(payload, l...)
+	}
+	return append(payload, 0, 0, 0, 0, 0)
+}
+
+func TestParseKexInit(t *testing.T) {
+	payload := kexInitPayload(
+		"curve25519-sha256,diffie-hellman-group1-sha1",
+		"rsa-sha2-512,ssh-ed25519",
+		"aes128-ctr,aes128-cbc", "aes128-ctr,3des-cbc",
+		"hmac-sha2-256", "hmac-sha2-256,hmac-md5",
+		"none", "none", "", "",
+	)
+	algos, err := parseKexInit(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SSHAlgorithms{
+		Kex:     []string{"curve25519-sha256", "diffie-hellman-group1-sha1"},
+		HostKey: []string{"rsa-sha2-512", "ssh-ed25519"},
+		Ciphers: []string{"aes128-ctr", "aes128-cbc", "3des-cbc"},
+		MACs:    []string{"hmac-sha2-256", "hmac-md5"},
+	}
+	if !reflect.DeepEqual(algos, expected) {
+		t.Errorf("parseKexInit() =>\n%#v\nwant\n%#v", algos, expected)
+	}
+	if _, err := parseKexInit(payload[:30]); err == nil {
+		t.Errorf("parseKexInit() did not return an error for a truncated message")
+	}
+	if _, err := parseKexInit([]byte{21}); err == nil {
+		t.Errorf("parseKexInit() did not return an error for the wrong message")
+	}
+}
+
+func TestFetchSSHAlgorithms(t *testing.T) {
+	signers := newTestSigners(t)
+	hostport, stop := listenSSH(t, signers[2])
+	defer stop()
+
+	algos, err := FetchSSHAlgorithms(hostport, 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(algos.HostKey, []string{"ssh-ed25519"}) {
+		t.Errorf("HostKey = %v", algos.HostKey)
+	}
+	if len(algos.Kex) == 0 || len(algos.Ciphers) == 0 || len(algos.MACs) == 0 {
+		t.Errorf("FetchSSHAlgorithms() => %#v", algos)
+	}
+}
+
+func TestAlgorithmPolicy(t *testing.T) {
+	h := Host{
+		Hostport:          "192.168.1.1:22",
+		KexAlgorithms:     "curve25519-sha256,diffie-hellman-group1-sha1",
+		HostKeyAlgorithms: "ssh-rsa,ssh-dss",
+		Ciphers:           "aes128-ctr,aes256-cbc,arcfour",
+		MACs:              "hmac-sha2-256,hmac-sha1-96",
+	}
+	var found []string
+	for _, f := range DefaultAlgorithmPolicy.Check(h) {
+		found = append(found, f.Type+" "+f.Algorithm)
+	}
+	expected := []string{
+		"kex diffie-hellman-group1-sha1",
+		"hostkey ssh-rsa",
+		"hostkey ssh-dss",
+		"cipher aes256-cbc",
+		"cipher arcfour",
+		"mac hmac-sha1-96",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Check() => %v, want %v", found, expected)
+	}
+
+	//ssh-rsa is fine alongside SHA-2 signatures
+	h.HostKeyAlgorithms = "rsa-sha2-512,rsa-sha2-256,ssh-rsa"
+	for _, f := range DefaultAlgorithmPolicy.Check(h) {
+		if f.Type == AlgoHostKey {
+			t.Errorf("unexpected finding %#v", f)
+		}
+	}
+
+	policy, err := LoadAlgorithmPolicy(strings.NewReader(`[{"Type": "cipher", "Pattern": "aes128-*", "Reason": "too short"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := policy.Check(h)
+	if len(findings) != 1 || findings[0].Algorithm != "aes128-ctr" || findings[0].Reason != "too short" {
+		t.Errorf("Check() with a loaded policy => %#v", findings)
+	}
+	for _, bad := range []string{`{}`, `[{"Type": "compression", "Pattern": "*"}]`, `[{"Type": "mac", "Pattern": "["}]`} {
+		if _, err := LoadAlgorithmPolicy(bytes.NewReader([]byte(bad))); err == nil {
+			t.Errorf("LoadAlgorithmPolicy(%s) did not return an error", bad)
+		}
+	}
+}
+
+func TestHostAlgorithms(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	host := SSHHost{
+		hostport: "192.168.1.1:22",
+		algos: SSHAlgorithms{
+			Kex:     []string{"curve25519-sha256"},
+			HostKey: []string{"ssh-ed25519"},
+			Ciphers: []string{"aes128-ctr", "aes128-cbc"},
+			MACs:    []string{"hmac-sha2-256"},
+		},
+	}
+	if err := s.addOrUpdateHost(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setHostAlgorithms(host); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := s.GetActiveHosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || !reflect.DeepEqual(hosts[0].Algorithms(), host.algos) {
+		t.Errorf("GetActiveHosts() => %#v", hosts)
+	}
+	findings, err := New(s).WeakAlgorithms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Algorithm != "aes128-cbc" {
+		t.Errorf("WeakAlgorithms() => %#v", findings)
+	}
+}
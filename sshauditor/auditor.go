@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,12 +19,33 @@ type ScanConfiguration struct {
 	Ports       []int
 	Concurrency int
 	Timeout     time.Duration
+	//AllowLargeIPv6 permits IPv6 ranges larger than a /112
+	AllowLargeIPv6 bool
+	//InsecureIgnoreHostKeys sends credentials to hosts presenting a key
+	//that wasn't seen during discovery
+	InsecureIgnoreHostKeys bool
+	//Resume continues the last interrupted scan or rescan from its
+	//checkpoint instead of queuing credentials anew
+	Resume bool
+	//MaxHostErrors is how many failed connections a host may cause before
+	//the rest of its credentials are deferred to the next run, Retries how
+	//often a connection failing with a transient error is retried and
+	//RetryBackoff the delay before the first retry.  Zero values use the
+	//defaults, except for Retries.
+	MaxHostErrors int
+	Retries       int
+	RetryBackoff  time.Duration
+	//PasswordsPerConnection is how many passwords for the same user are
+	//tried over one connection before opening another, 0 or 1 opens a
+	//connection per credential
+	PasswordsPerConnection int
 }
 type AuditResult struct {
-	totalCount int
-	negCount   int
-	posCount   int
-	errCount   int
+	totalCount    int
+	negCount      int
+	posCount      int
+	errCount      int
+	deferredCount int
 }
 type AuditReport struct {
 	ActiveHosts      []Host
@@ -34,6 +56,18 @@ type AuditReport struct {
 
 	Vulnerabilities      []Vulnerability
 	VulnerabilitiesCount int
+
+	FixedVulnerabilities      []Vulnerability
+	FixedVulnerabilitiesCount int
+
+	WeakAlgorithms      []AlgorithmFinding
+	WeakAlgorithmsCount int
+
+	Findings      []Finding
+	FindingsCount int
+
+	SoftwareVulnerabilities      []SoftwareFinding
+	SoftwareVulnerabilitiesCount int
 }
 
 func joinInts(ints []int, sep string) string {
@@ -45,45 +79,152 @@ func joinInts(ints []int, sep string) string {
 }
 
 //expandScanConfiguration takes a ScanConfiguration and returns a channel
-//of all hostports that match the scan configuration.
-func expandScanConfiguration(cfg ScanConfiguration) (chan string, error) {
+//of all hostports that match the scan configuration.  The channel is closed
+//early if ctx is canceled.
+func expandScanConfiguration(ctx context.Context, cfg ScanConfiguration) (chan string, error) {
 	hostChan := make(chan string, 1024)
-	hosts, err := EnumerateHosts(cfg.Include, cfg.Exclude)
+	hosts, err := NewHostEnumerator(cfg.Include, cfg.Exclude)
 	if err != nil {
 		return hostChan, err
 	}
+	if !cfg.AllowLargeIPv6 {
+		err = hosts.checkIPv6Size()
+		if err != nil {
+			return hostChan, err
+		}
+	}
 	log.Info("discovering hosts",
 		"include", strings.Join(cfg.Include, ","),
 		"exclude", strings.Join(cfg.Exclude, ","),
-		"total", len(hosts),
+		"total", hosts.Count().String(),
 		"ports", joinInts(cfg.Ports, ","),
 	)
 	go func() {
+		defer close(hostChan)
 		// Iterate over ports first, so for a large scan there's a
 		// delay between attempts per host
 		for _, port := range cfg.Ports {
 			portString := strconv.Itoa(port)
-			for _, h := range hosts {
-				hostChan <- net.JoinHostPort(h, portString)
+			it := hosts.Iterate()
+			for h, ok := it.Next(); ok; h, ok = it.Next() {
+				select {
+				case hostChan <- net.JoinHostPort(h, portString):
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
-		close(hostChan)
 	}()
 	return hostChan, err
 }
 
 type SSHAuditor struct {
-	//TODO: should be interface
-	store *SQLiteStore
+	store           Store
+	resolver        Resolver
+	algorithmPolicy AlgorithmPolicy
+	vulnFeed        VulnFeed
 }
 
-func New(store *SQLiteStore) *SSHAuditor {
+func New(store Store) *SSHAuditor {
 	return &SSHAuditor{
-		store: store,
+		store:           store,
+		resolver:        net.DefaultResolver,
+		algorithmPolicy: DefaultAlgorithmPolicy,
+	}
+}
+
+//SetResolver replaces the resolver used for hostname targets and reverse DNS
+func (a *SSHAuditor) SetResolver(r Resolver) {
+	a.resolver = r
+}
+
+//SetAlgorithmPolicy replaces the policy deciding which algorithms are weak
+func (a *SSHAuditor) SetAlgorithmPolicy(p AlgorithmPolicy) {
+	a.algorithmPolicy = p
+}
+
+//SetVulnFeed sets the feed of vulnerable software versions to match hosts
+//against.  Without one, no software vulnerabilities are reported.
+func (a *SSHAuditor) SetVulnFeed(f VulnFeed) {
+	a.vulnFeed = f
+}
+
+//resolveConfiguration returns a copy of cfg with hostnames in the include
+//and exclude lists replaced by their addresses, and the names each included
+//address was resolved from.  Included names that fail to resolve are
+//skipped, but an exclusion that can't be resolved is an error, since
+//ignoring it could scan hosts that were meant to be left alone.
+func (a *SSHAuditor) resolveConfiguration(cfg ScanConfiguration) (ScanConfiguration, map[string][]string, error) {
+	include, names, failed := resolveTargets(a.resolver, cfg.Include)
+	for _, f := range failed {
+		log.Warn("unable to resolve target", "host", f)
+	}
+	exclude, _, failed := resolveTargets(a.resolver, cfg.Exclude)
+	if len(failed) > 0 {
+		return cfg, nil, errors.Errorf("unable to resolve excluded hosts: %s", strings.Join(failed, ","))
+	}
+	cfg.Include = include
+	cfg.Exclude = exclude
+	return cfg, names, nil
+}
+
+//hostKeysChanged returns true if any of the keys of host replaced a stored
+//key of the same type.  Keys of a type that wasn't offered before are not a
+//change, nor are types that weren't fetched this time.
+func hostKeysChanged(host SSHHost, rec Host) bool {
+	if len(host.keys) == 0 || len(rec.Keys) == 0 {
+		//Hosts discovered before keys were collected per type only have a
+		//single fingerprint to compare
+		return host.keyfp != rec.Fingerprint
+	}
+	for _, k := range host.keys {
+		for _, old := range rec.Keys {
+			if k.Type == old.Type && k.Fingerprint != old.Fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//updateAlgorithmFindings records the algorithms host offers and the
+//findings that follow from them
+func (a *SSHAuditor) updateAlgorithmFindings(host SSHHost) error {
+	err := a.store.setHostAlgorithms(host)
+	if err != nil {
+		return err
+	}
+	modes := terrapinModes(host.algos)
+	if len(modes) == 0 {
+		return a.store.clearHostFinding(host.hostport, FindingTerrapin)
+	}
+	log.Info("host vulnerable to terrapin", "host", host.hostport, "modes", strings.Join(modes, "; "))
+	return a.store.addHostFinding(HostFinding{
+		Hostport: host.hostport,
+		Type:     FindingTerrapin,
+		Detail:   strings.Join(modes, "; "),
+	})
+}
+
+//updateAuthFindings records the authentication methods host advertises and
+//flags it if any of them accept a password
+func (a *SSHAuditor) updateAuthFindings(host SSHHost) error {
+	err := a.store.setHostAuthMethods(host)
+	if err != nil {
+		return err
 	}
+	pw := passwordAuthMethods(host.authMethods)
+	if len(pw) == 0 {
+		return a.store.clearHostFinding(host.hostport, FindingPasswordAuth)
+	}
+	return a.store.addHostFinding(HostFinding{
+		Hostport: host.hostport,
+		Type:     FindingPasswordAuth,
+		Detail:   strings.Join(pw, ","),
+	})
 }
 
-func (a *SSHAuditor) updateStoreFromDiscovery(hosts chan SSHHost) error {
+func (a *SSHAuditor) updateStoreFromDiscovery(run *ScanRun, hosts chan SSHHost, names map[string][]string) error {
 	knownHosts, err := a.store.getKnownHosts()
 	if err != nil {
 		return err
@@ -98,14 +239,19 @@ func (a *SSHAuditor) updateStoreFromDiscovery(hosts chan SSHHost) error {
 		}
 		close(hostsWrapped)
 	}()
-	for hostBatch := range batch(context.TODO(), hostsWrapped, 50, 2*time.Second) {
+	//The batches aren't canceled, discovery stops producing hosts instead, so
+	//hosts already found are still stored
+	for hostBatch := range batch(context.Background(), hostsWrapped, 50, 2*time.Second) {
 		_, err := a.store.Begin()
 		if err != nil {
 			return errors.Wrap(err, "updateStoreFromDiscovery")
 		}
 		for _, host := range hostBatch {
 			host := host.(SSHHost)
-			var needUpdate bool
+			if h, _, err := net.SplitHostPort(host.hostport); err == nil {
+				host.names = joinNames(names[h])
+			}
+			var needUpdate, namesChanged bool
 			rec, existing := knownHosts[host.hostport]
 			if existing {
 				if host.keyfp == "" {
@@ -114,8 +260,17 @@ func (a *SSHAuditor) updateStoreFromDiscovery(hosts chan SSHHost) error {
 				if host.version == "" {
 					host.version = rec.Version
 				}
-				needUpdate = (host.keyfp != rec.Fingerprint || host.version != rec.Version)
-				err := a.store.addHostChanges(host, rec)
+				//A host discovered by address keeps the names it was
+				//previously discovered under
+				if host.names == "" {
+					host.names = rec.Names
+				}
+				if host.ptrNames == "" {
+					host.ptrNames = rec.PTRNames
+				}
+				needUpdate = (hostKeysChanged(host, rec) || host.version != rec.Version)
+				namesChanged = (host.names != rec.Names || host.ptrNames != rec.PTRNames)
+				err := a.store.addHostChanges(run.ID, host, rec)
 				if err != nil {
 					return errors.Wrap(err, "updateStoreFromDiscovery")
 				}
@@ -127,6 +282,30 @@ func (a *SSHAuditor) updateStoreFromDiscovery(hosts chan SSHHost) error {
 					return errors.Wrap(err, "updateStoreFromDiscovery")
 				}
 			}
+			if len(host.keys) > 0 {
+				err = a.store.setHostKeys(host)
+				if err != nil {
+					return errors.Wrap(err, "updateStoreFromDiscovery")
+				}
+			}
+			if len(host.algos.Kex) > 0 {
+				err = a.updateAlgorithmFindings(host)
+				if err != nil {
+					return errors.Wrap(err, "updateStoreFromDiscovery")
+				}
+			}
+			if host.authMethods != nil {
+				err = a.updateAuthFindings(host)
+				if err != nil {
+					return errors.Wrap(err, "updateStoreFromDiscovery")
+				}
+			}
+			if existing && !needUpdate && namesChanged {
+				err = a.store.setHostNames(host)
+				if err != nil {
+					return errors.Wrap(err, "updateStoreFromDiscovery")
+				}
+			}
 			//If it already existed and we didn't otherwise update it, mark that it was seen
 			if existing {
 				err = a.store.setLastSeen(host)
@@ -149,6 +328,9 @@ func (a *SSHAuditor) updateStoreFromDiscovery(hosts chan SSHHost) error {
 		}
 	}
 	log.Info("discovery report", "total", totalCount, "new", newCount, "updated", updatedCount)
+	run.TotalCount = totalCount
+	run.NewCount = newCount
+	run.UpdatedCount = updatedCount
 	return nil
 }
 
@@ -165,17 +347,51 @@ func (a *SSHAuditor) updateQueues() error {
 	return nil
 }
 
-func (a *SSHAuditor) Discover(cfg ScanConfiguration) error {
+//Discover finds the ssh servers matching cfg and records them.  If ctx is
+//canceled, the servers already found are recorded and ctx's error returned.
+func (a *SSHAuditor) Discover(ctx context.Context, cfg ScanConfiguration) error {
+	resolved, names, err := a.resolveConfiguration(cfg)
+	if err != nil {
+		return err
+	}
 	//Push all candidate hosts into the banner fetcher queue
-	hostChan, err := expandScanConfiguration(cfg)
+	hostChan, err := expandScanConfiguration(ctx, resolved)
+	if err != nil {
+		return err
+	}
+
+	//The run records the targets as given, names and all
+	run := newScanRun("discover", cfg)
+	err = a.store.addScanRun(&run)
 	if err != nil {
 		return err
 	}
 
-	portResults := bannerFetcher(cfg.Concurrency*2, hostChan)
-	keyResults := fingerPrintFetcher(cfg.Concurrency, portResults)
+	portResults := bannerFetcher(ctx, cfg.Concurrency*2, hostChan)
+	return a.finishDiscovery(ctx, &run, cfg, portResults, names)
+}
+
+//finishDiscovery fetches the host keys of the open ports in portResults,
+//records the hosts and queues credentials to be checked against them
+func (a *SSHAuditor) finishDiscovery(ctx context.Context, run *ScanRun, cfg ScanConfiguration, portResults chan ScanResult, names map[string][]string) (err error) {
+	defer a.failScanRunOnError(ctx, run, &err)
+	keyResults := fingerPrintFetcher(ctx, cfg.Concurrency, portResults, a.resolver, cfg.Timeout)
 
-	err = a.updateStoreFromDiscovery(keyResults)
+	err = a.updateStoreFromDiscovery(run, keyResults, names)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		log.Warn("discovery interrupted", "run", run.ID)
+		err = a.store.interruptScanRun(*run, nil)
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	err = a.store.finishScanRun(*run)
 	if err != nil {
 		return err
 	}
@@ -184,22 +400,132 @@ func (a *SSHAuditor) Discover(cfg ScanConfiguration) error {
 	return err
 }
 
-func (a *SSHAuditor) brute(scantype string, cfg ScanConfiguration) (AuditResult, error) {
-	var res AuditResult
-	a.updateQueues()
-	var err error
+//DiscoverImported records the ssh servers among ports found by an external
+//scanner such as nmap or masscan, as if Discover had found them.  A port is
+//treated as ssh if the scanner identified it as such, it has an ssh banner or
+//it is one of cfg.Ports.  Only cfg.Exclude is used from cfg's targets.
+//Banners are fetched for ports the scanner didn't capture one for.
+func (a *SSHAuditor) DiscoverImported(ctx context.Context, source string, cfg ScanConfiguration, found []DiscoveredPort) error {
+	resolved, _, err := a.resolveConfiguration(ScanConfiguration{Exclude: cfg.Exclude})
+	if err != nil {
+		return err
+	}
+	excluded, err := NewHostEnumerator(nil, resolved.Exclude)
+	if err != nil {
+		return err
+	}
 
+	names := make(map[string][]string)
+	var ssh []DiscoveredPort
+	for _, d := range found {
+		if !d.isSSH(cfg.Ports) {
+			continue
+		}
+		if _, skip := excluded.excluding(net.ParseIP(d.Address).To16()); skip {
+			continue
+		}
+		ssh = append(ssh, d)
+		names[d.Address] = append(names[d.Address], d.Names...)
+	}
+	log.Info("importing discovery results", "source", source, "ports", len(found), "ssh", len(ssh))
+
+	cfg.Include = []string{source}
+	run := newScanRun("import", cfg)
+	err = a.store.addScanRun(&run)
+	if err != nil {
+		return err
+	}
+
+	//Ports with a banner go straight to fingerprinting, the rest have it
+	//fetched first
+	hostChan := make(chan string, 1024)
+	fetched := bannerFetcher(ctx, cfg.Concurrency, hostChan)
+	portResults := make(chan ScanResult, 1024)
+	go func() {
+		for _, d := range ssh {
+			if d.Banner == "" {
+				hostChan <- d.hostport()
+			}
+		}
+		close(hostChan)
+	}()
+	go func() {
+		for _, d := range ssh {
+			if d.Banner != "" {
+				portResults <- ScanResult{hostport: d.hostport(), success: true, banner: d.Banner}
+			}
+		}
+		for res := range fetched {
+			portResults <- res
+		}
+		close(portResults)
+	}()
+	return a.finishDiscovery(ctx, &run, cfg, portResults, names)
+}
+
+func (a *SSHAuditor) brute(ctx context.Context, scantype string, cfg ScanConfiguration) (res AuditResult, err error) {
+	var run ScanRun
 	var sc []ScanRequest
-	switch scantype {
-	case "scan":
-		sc, err = a.store.getScanQueue()
-	case "rescan":
-		sc, err = a.store.getRescanQueue()
+
+	if cfg.Resume {
+		run, sc, err = a.store.getCheckpoint(scantype)
+		if err != nil {
+			return res, errors.Wrap(err, "brute")
+		}
+		if run.ID == 0 {
+			log.Info("no interrupted run to resume", "type", scantype)
+		} else {
+			log.Info("resuming interrupted run", "run", run.ID, "type", scantype, "hosts", len(sc))
+		}
 	}
+	if run.ID == 0 {
+		err = a.updateQueues()
+		if err != nil {
+			return res, errors.Wrap(err, "brute")
+		}
+		switch scantype {
+		case "scan":
+			sc, err = a.store.getScanQueue()
+		case "rescan":
+			sc, err = a.store.getRescanQueue()
+		}
+		if err != nil {
+			return res, errors.Wrap(err, "Error getting scan queue")
+		}
+		//The queue covers whatever an interrupted run didn't get to
+		err = a.store.clearCheckpoints(scantype)
+		if err != nil {
+			return res, errors.Wrap(err, "brute")
+		}
+		run = newScanRun(scantype, cfg)
+		err = a.store.addScanRun(&run)
+		if err != nil {
+			return res, errors.Wrap(err, "brute")
+		}
+	}
+	defer a.failScanRunOnError(ctx, &run, &err)
+	probes, err := a.store.getHostProbes()
 	if err != nil {
-		return res, errors.Wrap(err, "Error getting scan queue")
+		return res, errors.Wrap(err, "brute")
 	}
-	bruteResults := bruteForcer(cfg.Concurrency, sc, cfg.Timeout)
+	//pending are the credentials of each host not yet tried, what is left
+	//of them if the run is interrupted is its checkpoint
+	pending := make(map[string]map[Credential]bool)
+	for i := range sc {
+		//Hosts are only probed again once their keys change, unless they
+		//accepted anything
+		p := probes[sc[i].hostport]
+		sc[i].probe = p.Probed == ""
+		sc[i].trustCodePrompt = p.Probed != "" && !p.ProbeCodePrompt
+		sc[i].pinHostKey = !cfg.InsecureIgnoreHostKeys
+		sc[i].passwordsPerConnection = cfg.PasswordsPerConnection
+		creds := make(map[Credential]bool)
+		for _, c := range sc[i].credentials {
+			creds[c] = true
+		}
+		pending[sc[i].hostport] = creds
+	}
+	bruteResults := bruteForcer(ctx, cfg.Concurrency, sc, cfg.Timeout, newRetryPolicy(cfg))
 
 	bruteResultsWrapped := make(chan interface{})
 	go func() {
@@ -209,8 +535,14 @@ func (a *SSHAuditor) brute(scantype string, cfg ScanConfiguration) (AuditResult,
 		close(bruteResultsWrapped)
 	}()
 
-	var totalCount, errCount, negCount, posCount int
-	for bruteBatch := range batch(context.TODO(), bruteResultsWrapped, 50, 2*time.Second) {
+	//A resumed run carries on counting where it stopped
+	totalCount, errCount, negCount, posCount := run.TotalCount, run.ErrCount, run.NegCount, run.PosCount
+	deferredCount := run.DeferredCount
+	var anythingCount int
+	deferredHosts := make(map[string]bool)
+	//The batches aren't canceled, the brute forcer stops instead, so the
+	//results of attempts in progress are still stored
+	for bruteBatch := range batch(context.Background(), bruteResultsWrapped, 50, 2*time.Second) {
 		_, err = a.store.Begin()
 		if err != nil {
 			return res, errors.Wrap(err, "brute")
@@ -218,14 +550,73 @@ func (a *SSHAuditor) brute(scantype string, cfg ScanConfiguration) (AuditResult,
 
 		for _, br := range bruteBatch {
 			br := br.(BruteForceResult)
+			if br.done {
+				delete(pending, br.hostport)
+				continue
+			}
+			if br.hostKeyMismatch != "" {
+				log.Error("host key does not match the discovered keys, not sending credentials until it is rediscovered",
+					"host", br.hostport, "presented", br.hostKeyMismatch)
+				errCount++
+				err = a.store.setHostKeyMismatch(run.ID, br.hostport, br.hostKeyMismatch)
+				if err != nil {
+					return res, err
+				}
+				continue
+			}
+			if br.rateLimit != 0 {
+				err = a.updateRateLimit(br)
+				if err != nil {
+					return res, err
+				}
+				continue
+			}
+			if br.probed {
+				err = a.updateAcceptsAnything(br)
+				if err != nil {
+					return res, err
+				}
+				if br.result == "" {
+					continue
+				}
+				anythingCount++
+				//The host's credentials weren't tried, they wait for
+				//their next interval rather than being probed every run
+				var skipped []Credential
+				for c := range pending[br.hostport] {
+					skipped = append(skipped, c)
+				}
+				err = a.store.setCredsSkipped(br.hostport, skipped)
+				if err != nil {
+					return res, err
+				}
+				delete(pending, br.hostport)
+				continue
+			}
+			delete(pending[br.hostport], br.cred)
 			l := log.New(
 				"host", br.hostport,
 				"user", br.cred.User,
 				"password", br.cred.Password,
 				"result", br.result,
 			)
+			//Deferred credentials stay queued for the next run
+			if br.deferred != "" {
+				l.Debug("deferred credential", "reason", br.deferred)
+				err = a.store.setCredDeferred(br)
+				if err != nil {
+					return res, err
+				}
+				if !deferredHosts[br.hostport] {
+					log.Warn("deferring the remaining credentials of host to the next run",
+						"host", br.hostport, "reason", br.deferred)
+				}
+				deferredHosts[br.hostport] = true
+				deferredCount++
+				continue
+			}
 			if br.err != nil {
-				l.Error("brute force error", "err", br.err.Error())
+				l.Error("brute force error", "type", ConnectionErrorType(br.err), "err", br.err.Error())
 				errCount++
 			} else if br.result == "" {
 				l.Debug("negative brute force result")
@@ -234,7 +625,7 @@ func (a *SSHAuditor) brute(scantype string, cfg ScanConfiguration) (AuditResult,
 				l.Info("positive brute force result")
 				posCount++
 			}
-			err = a.store.updateBruteResult(br)
+			err = a.store.updateBruteResult(run.ID, br)
 			if err != nil {
 				return res, err
 			}
@@ -245,20 +636,112 @@ func (a *SSHAuditor) brute(scantype string, cfg ScanConfiguration) (AuditResult,
 			return res, errors.Wrap(err, "brute")
 		}
 	}
-	log.Info("brute force scan report", "total", totalCount, "neg", negCount, "pos", posCount, "err", errCount)
-	return AuditResult{
-		totalCount: totalCount,
-		negCount:   negCount,
-		posCount:   posCount,
-		errCount:   errCount,
-	}, nil
+	log.Info("brute force scan report", "total", totalCount, "neg", negCount, "pos", posCount, "err", errCount,
+		"deferred", deferredCount, "accepts_anything", anythingCount)
+	res = AuditResult{
+		totalCount:    totalCount,
+		negCount:      negCount,
+		posCount:      posCount,
+		errCount:      errCount,
+		deferredCount: deferredCount,
+	}
+	run.TotalCount = totalCount
+	run.NegCount = negCount
+	run.PosCount = posCount
+	run.ErrCount = errCount
+	run.DeferredCount = deferredCount
+
+	if ctx.Err() != nil {
+		var remaining []ScanRequest
+		for hostport, creds := range pending {
+			sr := ScanRequest{hostport: hostport}
+			for c := range creds {
+				sr.credentials = append(sr.credentials, c)
+			}
+			remaining = append(remaining, sr)
+		}
+		log.Warn("scan interrupted, run it again with --resume to continue", "run", run.ID, "hosts", len(remaining))
+		err = a.store.interruptScanRun(run, remaining)
+		if err != nil {
+			return res, errors.Wrap(err, "brute")
+		}
+		return res, ctx.Err()
+	}
+	err = a.store.finishScanRun(run)
+	if err != nil {
+		return res, errors.Wrap(err, "brute")
+	}
+	return res, errors.Wrap(a.store.clearCheckpoints(scantype), "brute")
 }
 
-func (a *SSHAuditor) Scan(cfg ScanConfiguration) (AuditResult, error) {
-	return a.brute("scan", cfg)
+//failScanRunOnError marks run as failed if the function it is deferred in
+//returns an error other than ctx's, which interrupted runs return
+func (a *SSHAuditor) failScanRunOnError(ctx context.Context, run *ScanRun, err *error) {
+	if *err == nil || *err == ctx.Err() {
+		return
+	}
+	log.Error("run failed", "run", run.ID, "type", run.Type, "err", *err)
+	if ferr := a.store.failScanRun(*run, *err); ferr != nil {
+		log.Error("unable to record the failed run", "run", run.ID, "err", ferr)
+	}
 }
-func (a *SSHAuditor) Rescan(cfg ScanConfiguration) (AuditResult, error) {
-	return a.brute("rescan", cfg)
+
+//updateRateLimit records that a host started blocking connections, so that
+//later runs stay under the number it allowed
+func (a *SSHAuditor) updateRateLimit(br BruteForceResult) error {
+	if br.rateLimitRaised {
+		log.Info("host didn't block paced connections, raising its limit",
+			"host", br.hostport, "limit", br.rateLimit)
+		return a.store.setHostRateLimit(br.hostport, br.rateLimit)
+	}
+	log.Warn("host started blocking connections, later runs will stay under the limit",
+		"host", br.hostport, "allowed", br.rateLimit)
+	err := a.store.setHostRateLimit(br.hostport, br.rateLimit)
+	if err != nil {
+		return err
+	}
+	return a.store.addHostFinding(HostFinding{
+		Hostport: br.hostport,
+		Type:     FindingRateLimited,
+		Detail:   rateLimitDetail(br.rateLimit),
+	})
+}
+
+//updateAcceptsAnything records the result of probing a host with none auth
+//and a random password.  A failed probe leaves any earlier finding alone
+//and is tried again on the next run.
+func (a *SSHAuditor) updateAcceptsAnything(br BruteForceResult) error {
+	l := log.New("host", br.hostport)
+	if br.err != nil {
+		l.Debug("unable to probe host with a random credential", "type", ConnectionErrorType(br.err), "err", br.err.Error())
+		return nil
+	}
+	err := a.store.setHostProbed(br.hostport, br.codePrompt)
+	if err != nil {
+		return err
+	}
+	if br.result == "" {
+		return a.store.clearHostFinding(br.hostport, FindingAcceptsAnything)
+	}
+	l.Warn("host accepts any credential, skipping credentials", "access", br.result)
+	return a.store.addHostFinding(HostFinding{
+		Hostport: br.hostport,
+		Type:     FindingAcceptsAnything,
+		Detail:   br.result,
+	})
+}
+
+//Scan tries new and outdated credentials against the active hosts.  If ctx
+//is canceled, the results so far are recorded along with a checkpoint that
+//a scan with cfg.Resume set continues from, and ctx's error is returned.
+func (a *SSHAuditor) Scan(ctx context.Context, cfg ScanConfiguration) (AuditResult, error) {
+	return a.brute(ctx, "scan", cfg)
+}
+
+//Rescan tries the credentials that previously worked again, it is
+//interrupted and resumed the same way as Scan
+func (a *SSHAuditor) Rescan(ctx context.Context, cfg ScanConfiguration) (AuditResult, error) {
+	return a.brute(ctx, "rescan", cfg)
 }
 
 func (a *SSHAuditor) Dupes() (map[string][]Host, error) {
@@ -270,8 +753,16 @@ func (a *SSHAuditor) Dupes() (map[string][]Host, error) {
 		return keyMap, errors.Wrap(err, "Dupes")
 	}
 
+	//A host is listed under every key it offers, hosts without per type
+	//keys under their single fingerprint
 	for _, h := range hosts {
-		keyMap[h.Fingerprint] = append(keyMap[h.Fingerprint], h)
+		if len(h.Keys) == 0 {
+			keyMap[h.Fingerprint] = append(keyMap[h.Fingerprint], h)
+			continue
+		}
+		for _, k := range h.Keys {
+			keyMap[k.Fingerprint] = append(keyMap[k.Fingerprint], h)
+		}
 	}
 
 	for fp, hosts := range keyMap {
@@ -306,24 +797,43 @@ func (a *SSHAuditor) getLogCheckScanQueue() ([]ScanRequest, error) {
 	return requests, nil
 }
 
-func (a *SSHAuditor) Logcheck(cfg ScanConfiguration) error {
+func (a *SSHAuditor) Logcheck(ctx context.Context, cfg ScanConfiguration) (err error) {
 	sc, err := a.getLogCheckScanQueue()
 	if err != nil {
 		return err
 	}
 
-	bruteResults := bruteForcer(cfg.Concurrency, sc, cfg.Timeout)
+	run := newScanRun("logcheck", cfg)
+	err = a.store.addScanRun(&run)
+	if err != nil {
+		return err
+	}
+	defer a.failScanRunOnError(ctx, &run, &err)
+
+	bruteResults := bruteForcer(ctx, cfg.Concurrency, sc, cfg.Timeout, newRetryPolicy(cfg))
 
 	for br := range bruteResults {
+		if br.done {
+			continue
+		}
+		run.TotalCount++
 		l := log.New("host", br.hostport, "user", br.cred.User)
 		if br.err != nil {
 			l.Error("Failed to send logcheck auth request", "error", br.err)
+			run.ErrCount++
 			continue
 		}
 		l.Info("Sent logcheck auth request")
 		//TODO Collect hostports and return them for syslog cross referencing
 	}
-	return nil
+	if ctx.Err() != nil {
+		err = a.store.interruptScanRun(run, nil)
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+	return a.store.finishScanRun(run)
 }
 
 func (a *SSHAuditor) LogcheckReport(ls LogSearcher) error {
@@ -360,9 +870,53 @@ func (a *SSHAuditor) Vulnerabilities() ([]Vulnerability, error) {
 	return a.store.GetVulnerabilities()
 }
 
+//FixedVulnerabilities returns previously vulnerable credentials that have
+//since stopped working
+func (a *SSHAuditor) FixedVulnerabilities() ([]Vulnerability, error) {
+	return a.store.GetFixedVulnerabilities()
+}
+
+//WeakAlgorithms returns the weak algorithms offered by active hosts
+//according to the algorithm policy
+func (a *SSHAuditor) WeakAlgorithms() ([]AlgorithmFinding, error) {
+	var findings []AlgorithmFinding
+	hosts, err := a.store.GetActiveHosts(reportMaxAgeDays)
+	if err != nil {
+		return findings, errors.Wrap(err, "WeakAlgorithms")
+	}
+	for _, h := range hosts {
+		findings = append(findings, a.algorithmPolicy.Check(h)...)
+	}
+	return findings, nil
+}
+
+//SoftwareVulnerabilities returns the vulnerabilities in the vulnerability
+//feed that affect the software active hosts run, most severe first
+func (a *SSHAuditor) SoftwareVulnerabilities() ([]SoftwareFinding, error) {
+	var findings []SoftwareFinding
+	hosts, err := a.store.GetActiveHosts(reportMaxAgeDays)
+	if err != nil {
+		return findings, errors.Wrap(err, "SoftwareVulnerabilities")
+	}
+	for _, h := range hosts {
+		sw := ParseBanner(h.Version)
+		for _, e := range a.vulnFeed.Match(sw) {
+			findings = append(findings, SoftwareFinding{Host: h, Software: sw, VulnFeedEntry: e})
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) > severityRank(findings[j].Severity)
+	})
+	return findings, nil
+}
+
+//reportMaxAgeDays is how recently a host must have been seen to be included
+//in the reports
+const reportMaxAgeDays = 2
+
 func (a *SSHAuditor) GetReport() (AuditReport, error) {
 	var rep AuditReport
-	hosts, err := a.store.GetActiveHosts(2)
+	hosts, err := a.store.GetActiveHosts(reportMaxAgeDays)
 	rep.ActiveHosts = hosts
 	rep.ActiveHostsCount = len(hosts)
 	if err != nil {
@@ -383,5 +937,33 @@ func (a *SSHAuditor) GetReport() (AuditReport, error) {
 	rep.Vulnerabilities = vulns
 	rep.VulnerabilitiesCount = len(vulns)
 
+	fixed, err := a.FixedVulnerabilities()
+	if err != nil {
+		return rep, err
+	}
+	rep.FixedVulnerabilities = fixed
+	rep.FixedVulnerabilitiesCount = len(fixed)
+
+	weak, err := a.WeakAlgorithms()
+	if err != nil {
+		return rep, err
+	}
+	rep.WeakAlgorithms = weak
+	rep.WeakAlgorithmsCount = len(weak)
+
+	findings, err := a.store.GetFindings()
+	if err != nil {
+		return rep, err
+	}
+	rep.Findings = findings
+	rep.FindingsCount = len(findings)
+
+	software, err := a.SoftwareVulnerabilities()
+	if err != nil {
+		return rep, err
+	}
+	rep.SoftwareVulnerabilities = software
+	rep.SoftwareVulnerabilitiesCount = len(software)
+
 	return rep, nil
 }
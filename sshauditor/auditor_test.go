@@ -1,10 +1,14 @@
 package sshauditor
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 //makeScanConfig returns a ScanConfiguration based on a single host:port
@@ -22,8 +26,8 @@ func makeScanConfig(hostport string) (ScanConfiguration, string, error) {
 	if len(ips) != 1 {
 		return sc, "", fmt.Errorf("Resolving of %s failed to return a sigle ip: %#v", host, ips)
 	}
-	scanDestination := fmt.Sprintf("%s/32", ips[0])
-	ipport := fmt.Sprintf("%s:%s", ips[0], port)
+	scanDestination := ips[0]
+	ipport := net.JoinHostPort(ips[0], port)
 	portInt, err := strconv.Atoi(port)
 	if err != nil {
 		return sc, "", err
@@ -68,11 +72,11 @@ func TestSSHAuditorE2E(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			err = auditor.Discover(sc)
+			err = auditor.Discover(context.Background(), sc)
 			if err != nil {
 				t.Fatal(err)
 			}
-			ar, err := auditor.Scan(sc)
+			ar, err := auditor.Scan(context.Background(), sc)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -114,3 +118,421 @@ func TestSSHAuditorE2E(t *testing.T) {
 		})
 	}
 }
+
+func TestHostKeysChanged(t *testing.T) {
+	rsa := HostKey{Type: "ssh-rsa", Fingerprint: "SHA256:rsa"}
+	rotated := HostKey{Type: "ssh-rsa", Fingerprint: "SHA256:rsa2"}
+	ed25519 := HostKey{Type: "ssh-ed25519", Fingerprint: "SHA256:ed25519"}
+	rec := Host{Fingerprint: rsa.Fingerprint, Keys: []HostKey{rsa}}
+	var testCases = []struct {
+		name     string
+		host     SSHHost
+		rec      Host
+		expected bool
+	}{
+		{"unchanged", SSHHost{keyfp: rsa.Fingerprint, keys: []HostKey{rsa}}, rec, false},
+		{"key added", SSHHost{keyfp: rsa.Fingerprint, keys: []HostKey{rsa, ed25519}}, rec, false},
+		{"preferred type only", SSHHost{keyfp: ed25519.Fingerprint, keys: []HostKey{ed25519}}, rec, false},
+		{"rotated", SSHHost{keyfp: rotated.Fingerprint, keys: []HostKey{rotated, ed25519}}, rec, true},
+		{"legacy unchanged", SSHHost{keyfp: rsa.Fingerprint, keys: []HostKey{rsa}}, Host{Fingerprint: rsa.Fingerprint}, false},
+		{"legacy changed", SSHHost{keyfp: rotated.Fingerprint, keys: []HostKey{rotated}}, Host{Fingerprint: rsa.Fingerprint}, true},
+	}
+	for _, tt := range testCases {
+		if got := hostKeysChanged(tt.host, tt.rec); got != tt.expected {
+			t.Errorf("hostKeysChanged(%s) => %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestDupes(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	shared := HostKey{Type: "ssh-ed25519", Fingerprint: "SHA256:shared"}
+	hosts := []SSHHost{
+		{hostport: "192.168.1.1:22", keyfp: "SHA256:rsa1", keys: []HostKey{{Type: "ssh-rsa", Fingerprint: "SHA256:rsa1"}, shared}},
+		{hostport: "192.168.1.2:22", keyfp: "SHA256:rsa2", keys: []HostKey{{Type: "ssh-rsa", Fingerprint: "SHA256:rsa2"}, shared}},
+		{hostport: "192.168.1.3:22", keyfp: "SHA256:rsa3", keys: []HostKey{{Type: "ssh-rsa", Fingerprint: "SHA256:rsa3"}}},
+	}
+	for _, h := range hosts {
+		if err := s.addOrUpdateHost(h); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.setHostKeys(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dupes, err := New(s).Dupes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dupes) != 1 || len(dupes[shared.Fingerprint]) != 2 {
+		t.Errorf("Dupes() => %#v, want 2 hosts sharing %s", dupes, shared.Fingerprint)
+	}
+}
+
+func TestScanAcceptsAnything(t *testing.T) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	signer := newTestSigners(t)[2]
+	config.AddHostKey(signer)
+	hostport, stop := listenSSHShell(t, config, "uid=0(root) gid=0(root)\n")
+	defer stop()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddCredential(Credential{User: "root", Password: "root", ScanInterval: 14}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addOrUpdateHost(SSHHost{hostport: hostport, keyfp: ssh.FingerprintSHA256(signer.PublicKey())}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := New(s).Scan(context.Background(), ScanConfiguration{Concurrency: 1, Timeout: 4 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.totalCount != 0 {
+		t.Errorf("Scan() tried %d credentials on a host accepting anything", res.totalCount)
+	}
+	findings, err := s.GetFindings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Type != FindingAcceptsAnything ||
+		findings[0].Detail != AnythingPassword+": "+AccessOpen {
+		t.Errorf("GetFindings() => %#v", findings)
+	}
+	//The skipped credentials aren't probed for again on the next scan
+	if n, err := s.getScanQueueSize(); err != nil || n != 0 {
+		t.Errorf("getScanQueueSize() => %d, %v after skipping the host", n, err)
+	}
+}
+
+func TestScanHostKeyMismatch(t *testing.T) {
+	sent := make(chan string, 10)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			sent <- string(password)
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	signers := newTestSigners(t)
+	config.AddHostKey(signers[2])
+	hostport, stop := listenSSHConfig(t, config)
+	defer stop()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddCredential(Credential{User: "root", Password: "root", ScanInterval: 14}); err != nil {
+		t.Fatal(err)
+	}
+	//Discovered with a different key than the one the server presents
+	discovered := SSHHost{
+		hostport: hostport,
+		keyfp:    ssh.FingerprintSHA256(signers[1].PublicKey()),
+		keys: []HostKey{{Hostport: hostport, Type: signers[1].PublicKey().Type(),
+			Fingerprint: ssh.FingerprintSHA256(signers[1].PublicKey())}},
+	}
+	if err := s.addOrUpdateHost(discovered); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setHostKeys(discovered); err != nil {
+		t.Fatal(err)
+	}
+	a := New(s)
+	res, err := a.Scan(context.Background(), ScanConfiguration{Concurrency: 1, Timeout: 4 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 || res.errCount != 1 {
+		t.Errorf("Scan() sent %d passwords to a host with the wrong key, %d errors", len(sent), res.errCount)
+	}
+	var changes []string
+	if err := s.Select(&changes, `SELECT new FROM host_changes WHERE type='key mismatch'`); err != nil {
+		t.Fatal(err)
+	}
+	presented := signers[2].PublicKey().Type() + " " + ssh.FingerprintSHA256(signers[2].PublicKey())
+	if len(changes) != 1 || changes[0] != presented {
+		t.Errorf("key mismatch changes => %q, want %q", changes, presented)
+	}
+	queue, err := s.getScanQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Errorf("getScanQueue() => %v, want the host left out", queue)
+	}
+
+	//Until discovery accepts the new key
+	discovered.keyfp = ssh.FingerprintSHA256(signers[2].PublicKey())
+	discovered.keys = []HostKey{{Hostport: hostport, Type: signers[2].PublicKey().Type(), Fingerprint: discovered.keyfp}}
+	if err := s.setHostKeys(discovered); err != nil {
+		t.Fatal(err)
+	}
+	res, err = a.Scan(context.Background(), ScanConfiguration{Concurrency: 1, Timeout: 4 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The random password of the probe and the credential
+	if len(sent) != 2 || res.negCount != 1 {
+		t.Errorf("Scan() after rediscovery sent %d passwords, %d negative results", len(sent), res.negCount)
+	}
+}
+
+func TestScanResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := make(chan string, 10)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			//The probe's random credential doesn't count
+			if c.User() == "root" {
+				sent <- string(password)
+				cancel()
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	signer := newTestSigners(t)[2]
+	config.AddHostKey(signer)
+	hostport, stop := listenSSHConfig(t, config)
+	defer stop()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		if _, err := s.AddCredential(Credential{User: "root", Password: p, ScanInterval: 14}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.addOrUpdateHost(SSHHost{hostport: hostport, keyfp: ssh.FingerprintSHA256(signer.PublicKey())}); err != nil {
+		t.Fatal(err)
+	}
+	a := New(s)
+	cfg := ScanConfiguration{Concurrency: 1, Timeout: 4 * time.Second}
+	_, err = a.Scan(ctx, cfg)
+	if err != context.Canceled {
+		t.Fatalf("Scan() => %v, want %v", err, context.Canceled)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("Scan() sent %d passwords before stopping, want 1", len(sent))
+	}
+	runs, err := s.GetScanRuns(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Interrupted == "" || runs[0].TotalCount != 1 {
+		t.Fatalf("GetScanRuns() => %#v, want one interrupted run", runs)
+	}
+
+	cfg.Resume = true
+	res, err := a.Scan(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(sent)
+	seen := make(map[string]bool)
+	for p := range sent {
+		if seen[p] {
+			t.Errorf("password %q was sent again", p)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 3 || res.totalCount != 3 {
+		t.Errorf("Scan() with resume tried %v, %d in total, want every password once", seen, res.totalCount)
+	}
+	runs, err = s.GetScanRuns(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Interrupted != "" || runs[0].TotalCount != 3 {
+		t.Errorf("GetScanRuns() => %#v, want the run completed", runs)
+	}
+	run, queue, err := s.getCheckpoint("scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ID != 0 || len(queue) != 0 {
+		t.Errorf("getCheckpoint() => %v %v, want nothing left to resume", run, queue)
+	}
+}
+
+func TestScanDeferred(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	hostport := l.Addr().String()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		if _, err := s.AddCredential(Credential{User: "root", Password: p, ScanInterval: 14}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.addOrUpdateHost(SSHHost{hostport: hostport, keyfp: "fp"}); err != nil {
+		t.Fatal(err)
+	}
+	//The failed probe and one credential use up the budget
+	cfg := ScanConfiguration{Concurrency: 1, Timeout: time.Second, MaxHostErrors: 2}
+	res, err := New(s).Scan(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.errCount != 1 || res.deferredCount != 2 {
+		t.Errorf("Scan() => %d errors, %d deferred, want 1 and 2", res.errCount, res.deferredCount)
+	}
+	runs, err := s.GetScanRuns(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].DeferredCount != 2 {
+		t.Errorf("GetScanRuns() => %#v, want 2 deferred", runs)
+	}
+	queue, err := s.getScanQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Neither the failed nor the deferred credentials count as tested
+	if len(queue) != 1 || len(queue[0].credentials) != 3 {
+		t.Errorf("getScanQueue() => %v, want the credentials still queued", queue)
+	}
+	//The deferred credentials are reported per host
+	hosts, err := s.GetHostErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Deferred[DeferredErrors] != 2 || hosts[0].LastDeferred == "" {
+		t.Errorf("GetHostErrors() => %+v, want 2 credentials deferred", hosts)
+	}
+}
+
+//failingStore is a store that can't record brute force results
+type failingStore struct {
+	*SQLiteStore
+}
+
+func (s failingStore) updateBruteResult(runID int64, br BruteForceResult) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestScanFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	hostport := l.Addr().String()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddCredential(Credential{User: "root", Password: "root", ScanInterval: 14}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addOrUpdateHost(SSHHost{hostport: hostport, keyfp: "fp"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := ScanConfiguration{Concurrency: 1, Timeout: time.Second}
+	if _, err := New(failingStore{s}).Scan(context.Background(), cfg); err == nil {
+		t.Fatal("Scan() => nil error with a store that can't record results")
+	}
+	runs, err := s.GetScanRuns(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Finished == "" || runs[0].Failed != "disk full" {
+		t.Errorf("GetScanRuns() => %#v, want the run finished as failed", runs)
+	}
+}
+
+func TestHostProbes(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	check(s.Init())
+	host := SSHHost{hostport: "192.168.1.1:22", keyfp: "a"}
+	check(s.addOrUpdateHost(host))
+	probed := func() Host {
+		probes, err := s.getHostProbes()
+		check(err)
+		return probes[host.hostport]
+	}
+	if p := probed(); p.Probed != "" {
+		t.Errorf("getHostProbes() => %+v, want a new host to be probed", p)
+	}
+	check(s.setHostProbed(host.hostport, true))
+	if p := probed(); p.Probed == "" || !p.ProbeCodePrompt {
+		t.Errorf("getHostProbes() => %+v after probing the host", p)
+	}
+	//A host accepting anything is probed on every run
+	check(s.addHostFinding(HostFinding{Hostport: host.hostport, Type: FindingAcceptsAnything, Detail: AnythingNone}))
+	if p := probed(); p.Probed != "" {
+		t.Errorf("getHostProbes() => %+v, want a host accepting anything to be probed", p)
+	}
+	check(s.clearHostFinding(host.hostport, FindingAcceptsAnything))
+	if p := probed(); p.Probed == "" {
+		t.Errorf("getHostProbes() => %+v, want the probe kept", p)
+	}
+	//And so is a host whose keys changed
+	host.keyfp = "b"
+	check(s.addOrUpdateHost(host))
+	if p := probed(); p.Probed != "" {
+		t.Errorf("getHostProbes() => %+v, want a changed host to be probed", p)
+	}
+}
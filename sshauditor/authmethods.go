@@ -0,0 +1,141 @@
+package sshauditor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+//Authentication methods from RFC 4252 and RFC 4462
+const (
+	AuthNone                = "none"
+	AuthPassword            = "password"
+	AuthPublicKey           = "publickey"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	AuthGSSAPI              = "gssapi-with-mic"
+)
+
+//authProbeUser is the user the advertised methods are probed for
+const authProbeUser = "root"
+
+//errAuthMethodProbed aborts authentication once the server picked a method
+var errAuthMethodProbed = errors.New("auth method probed")
+
+//probeGSSAPIClient is a GSSAPIClient that aborts before sending a token
+type probeGSSAPIClient struct {
+	probed func()
+}
+
+func (g probeGSSAPIClient) InitSecContext(target string, token []byte, isGSSDelegCreds bool) ([]byte, bool, error) {
+	g.probed()
+	return nil, false, errAuthMethodProbed
+}
+
+func (g probeGSSAPIClient) GetMIC(micField []byte) ([]byte, error) {
+	return nil, errAuthMethodProbed
+}
+
+func (g probeGSSAPIClient) DeleteSecContext() error {
+	return nil
+}
+
+//probeAuthMethod returns an AuthMethod for method that calls probed and
+//aborts authentication as soon as the server accepts the method, before any
+//secret is sent
+func probeAuthMethod(method string, probed func()) ssh.AuthMethod {
+	switch method {
+	case AuthPassword:
+		return ssh.PasswordCallback(func() (string, error) {
+			probed()
+			return "", errAuthMethodProbed
+		})
+	case AuthKeyboardInteractive:
+		return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			probed()
+			return nil, errAuthMethodProbed
+		})
+	case AuthGSSAPI:
+		return ssh.GSSAPIWithMICAuthMethod(probeGSSAPIClient{probed}, "")
+	}
+	return nil
+}
+
+//FetchSSHAuthMethods returns the authentication methods hostport advertises
+//after a failed none request.  The ssh client doesn't expose the list, so
+//each connection offers callbacks for the methods not yet found and the
+//server's pick is recorded and aborted, taking at most one connection per
+//advertised method.  Methods other than the AuthX constants aren't found.
+//If the server accepts none authentication, only AuthNone is returned.
+func FetchSSHAuthMethods(hostport string, timeout time.Duration) ([]string, error) {
+	var publicKey bool
+	var found []string
+	//gssapi-with-mic is last as a server declining it ends the probe
+	candidates := []string{AuthPassword, AuthKeyboardInteractive, AuthGSSAPI}
+	for len(candidates) > 0 {
+		probed := -1
+		auth := []ssh.AuthMethod{
+			//Offering no keys costs nothing, so publickey is always tried
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				publicKey = true
+				return nil, nil
+			}),
+		}
+		for i, m := range candidates {
+			i := i
+			auth = append(auth, probeAuthMethod(m, func() { probed = i }))
+		}
+		config := &ssh.ClientConfig{
+			User:            authProbeUser,
+			Auth:            auth,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         timeout,
+			ClientVersion:   "SSH-2.0-Go-ssh-auditor",
+		}
+		client, err := DialWithDeadline("tcp", hostport, config)
+		if err == nil {
+			client.Close()
+			return []string{AuthNone}, nil
+		}
+		if probed == -1 {
+			msg := err.Error()
+			if !strings.Contains(msg, "unable to authenticate") && !strings.Contains(msg, "parse error") {
+				return nil, err
+			}
+			break
+		}
+		found = append(found, candidates[probed])
+		//The server would have picked any earlier candidate it offered
+		candidates = candidates[probed+1:]
+	}
+	if publicKey {
+		found = append([]string{AuthPublicKey}, found...)
+	}
+	return found, nil
+}
+
+//authMethodsAllow returns true if a credential with password, which may be
+//a private key, can possibly work on a host advertising methods.  Nothing is
+//ruled out for hosts whose methods are unknown.
+func authMethodsAllow(methods []string, password string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	want := []string{AuthNone, AuthPassword, AuthKeyboardInteractive}
+	if isPrivateKey(password) {
+		want = []string{AuthNone, AuthPublicKey}
+	}
+	return containsAny(methods, want)
+}
+
+//passwordAuthMethods returns the methods in methods that accept a password
+func passwordAuthMethods(methods []string) []string {
+	var pw []string
+	for _, m := range methods {
+		if m == AuthPassword || m == AuthKeyboardInteractive {
+			pw = append(pw, m)
+		}
+	}
+	return pw
+}
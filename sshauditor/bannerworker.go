@@ -1,14 +1,24 @@
 package sshauditor
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
-func bannerWorker(jobs <-chan string, results chan<- ScanResult) {
+func bannerWorker(ctx context.Context, jobs <-chan string, results chan<- ScanResult) {
 	for host := range jobs {
+		//Hosts already queued are drained without being scanned
+		if ctx.Err() != nil {
+			continue
+		}
 		results <- ScanPort(host)
 	}
 }
 
-func bannerFetcher(numWorkers int, hostports <-chan string) chan ScanResult {
+//bannerFetcher scans hostports using numWorkers workers.  Once ctx is
+//canceled the remaining hostports are skipped and the results channel is
+//closed when hostports is.
+func bannerFetcher(ctx context.Context, numWorkers int, hostports <-chan string) chan ScanResult {
 	var wg sync.WaitGroup
 
 	results := make(chan ScanResult, 1024)
@@ -16,7 +26,7 @@ func bannerFetcher(numWorkers int, hostports <-chan string) chan ScanResult {
 	for w := 0; w <= numWorkers; w++ {
 		wg.Add(1)
 		go func() {
-			bannerWorker(hostports, results)
+			bannerWorker(ctx, hostports, results)
 			wg.Done()
 		}()
 	}
@@ -1,6 +1,8 @@
 package sshauditor
 
 import (
+	"context"
+	"sort"
 	"sync"
 	"time"
 )
@@ -8,6 +10,21 @@ import (
 type ScanRequest struct {
 	hostport    string
 	credentials []Credential
+	//probe checks if the host lets anything in before trying credentials.
+	//Without it trustCodePrompt is what an earlier probe found, see
+	//authOptions.
+	probe           bool
+	trustCodePrompt bool
+	//pinHostKey refuses to send anything to the host unless it presents
+	//one of hostKeys, the fingerprints seen during discovery
+	pinHostKey bool
+	hostKeys   []string
+	//maxConnections limits the connections made to a host known to block
+	//scanners, 0 means no limit
+	maxConnections int
+	//passwordsPerConnection is how many passwords for the same user are
+	//tried over one connection, 0 or 1 for a connection per credential
+	passwordsPerConnection int
 }
 
 type BruteForceResult struct {
@@ -15,48 +32,255 @@ type BruteForceResult struct {
 	cred     Credential
 	err      error
 	result   string
+	//prompts are the keyboard-interactive prompts the server sent
+	prompts []string
+	//probed is set on the result of the probe of a ScanRequest, result is
+	//then what SSHAcceptsAnything returned and codePrompt is set if the
+	//random password got a second factor prompt
+	probed     bool
+	codePrompt bool
+	//hostKeyMismatch is the type and fingerprint of a host key that wasn't
+	//pinned, the host is abandoned after this result
+	hostKeyMismatch string
+	//done is set on the result sent once a ScanRequest is finished with,
+	//requests cut short by cancellation don't get one
+	done bool
+	//deferred is why a credential wasn't tried, one of the DeferredX
+	//constants
+	deferred string
+	//rateLimit is how many connections the host answered before it started
+	//blocking them, or with rateLimitRaised set, the limit of a host that
+	//didn't block any of the connections of a paced run
+	rateLimit       int
+	rateLimitRaised bool
 }
 
-func bruteworker(jobs <-chan ScanRequest, results chan<- BruteForceResult, timeout time.Duration) {
+//Reasons for deferring credentials to the next run
+const (
+	DeferredErrors    = "too many errors from host"
+	DeferredRateLimit = "host rate limits connections"
+)
+
+func bruteworker(ctx context.Context, jobs <-chan ScanRequest, results chan<- BruteForceResult, timeout time.Duration, policy retryPolicy) {
 	for sr := range jobs {
-		failures := 0
-		for _, cred := range sr.credentials {
-			//TODO: make this configurable
-			//After 5 connection errors, stop trying this host for this run
-			if failures > 5 {
-				continue
+		//Requests already queued are drained without being started
+		if ctx.Err() != nil {
+			continue
+		}
+		if bruteHost(ctx, sr, results, timeout, policy) {
+			results <- BruteForceResult{hostport: sr.hostport, done: true}
+		}
+	}
+}
+
+//bruteHost tries the credentials of sr, returning false if ctx was canceled
+//before they were all dealt with.  Every failed connection is charged to
+//the host's error budget, once it runs out or sr.maxConnections is reached
+//the remaining credentials are reported as deferred.  With
+//sr.passwordsPerConnection set, passwords for the same user share a
+//connection until the server stops answering more than one.
+func bruteHost(ctx context.Context, sr ScanRequest, results chan<- BruteForceResult, timeout time.Duration, policy retryPolicy) bool {
+	var lockout lockoutDetector
+	conns := 0
+	defer func() {
+		if n := lockout.threshold(); n > 0 {
+			results <- BruteForceResult{hostport: sr.hostport, rateLimit: n}
+		} else if sr.maxConnections > 0 && conns >= sr.maxConnections && !lockout.blocked() {
+			results <- BruteForceResult{
+				hostport:        sr.hostport,
+				rateLimit:       raisedRateLimit(sr.maxConnections),
+				rateLimitRaised: true,
 			}
-			result, err := SSHAuthAttempt(sr.hostport, cred.User, cred.Password, timeout)
-			res := BruteForceResult{
-				hostport: sr.hostport,
-				cred:     cred,
-				result:   result,
-				err:      err,
+		}
+	}()
+	//paced returns true if n more connections stay within sr.maxConnections
+	paced := func(n int) bool {
+		return sr.maxConnections == 0 || conns+n <= sr.maxConnections
+	}
+	deferRest := func(creds []Credential, reason string) {
+		for _, c := range creds {
+			results <- BruteForceResult{hostport: sr.hostport, cred: c, deferred: reason}
+		}
+	}
+	opts := authOptions{trustCodePrompt: sr.trustCodePrompt}
+	var pin *hostKeyPin
+	if sr.pinHostKey {
+		pin = &hostKeyPin{fingerprints: sr.hostKeys}
+		opts.hostKeyCallback = pin.check
+	}
+	//mismatched reports a host key mismatch, if there was one
+	mismatched := func() bool {
+		if pin == nil || pin.presented == "" {
+			return false
+		}
+		results <- BruteForceResult{
+			hostport:        sr.hostport,
+			err:             errHostKeyMismatch,
+			hostKeyMismatch: pin.presented,
+		}
+		return true
+	}
+	errs := 0
+	//The probe takes up to two connections, it's skipped unless that leaves
+	//room for a credential
+	if sr.probe && paced(3) {
+		anything, codePrompt, n, err := acceptsAnything(sr.hostport, timeout, opts.hostKeyCallback)
+		if mismatched() {
+			return true
+		}
+		conns += n
+		//Only the last connection of the probe can have failed
+		if err == nil {
+			lockout.record(n, nil)
+		} else if n > 0 {
+			lockout.record(n-1, nil)
+			lockout.record(1, err)
+		}
+		//A second factor prompt after a password is only evidence the
+		//password was right if a random one doesn't get it too
+		opts.trustCodePrompt = err == nil && !codePrompt
+		results <- BruteForceResult{
+			hostport:   sr.hostport,
+			result:     anything,
+			err:        err,
+			probed:     true,
+			codePrompt: codePrompt,
+		}
+		if err != nil {
+			errs++
+		}
+		//Every credential would work, so none of them say anything
+		if anything != "" {
+			return true
+		}
+	}
+	creds := sr.credentials
+	perConn := sr.passwordsPerConnection
+	//batchErr is a batch connection that failed before any password was
+	//answered, it stands for the first attempt of the credential after it
+	var batchErr error
+	if perConn > 1 {
+		//Passwords for the same user are tried together
+		creds = append([]Credential(nil), creds...)
+		sort.SliceStable(creds, func(i, j int) bool { return creds[i].User < creds[j].User })
+	}
+	for i := 0; i < len(creds); {
+		if ctx.Err() != nil {
+			return false
+		}
+		//The circuit breaker is open, the host is parked for the rest of
+		//the run and its credentials wait for the next one
+		if errs >= policy.maxErrors {
+			deferRest(creds[i:], DeferredErrors)
+			return true
+		}
+		if !paced(1) {
+			deferRest(creds[i:], DeferredRateLimit)
+			return true
+		}
+		if batch := passwordBatch(creds[i:], perConn); len(batch) > 1 {
+			passwords := make([]string, len(batch))
+			for j, c := range batch {
+				passwords[j] = c.Password
+			}
+			answered, err := sshPasswordAttempts(sr.hostport, batch[0].User, passwords, timeout, opts)
+			if mismatched() {
+				return true
 			}
-			results <- res
+			conns++
+			for j, result := range answered {
+				results <- BruteForceResult{hostport: sr.hostport, cred: batch[j], result: result}
+			}
+			if len(answered) == 0 {
+				//Nothing was answered, the credentials are tried a
+				//connection at a time from now on
+				perConn = 1
+				if err != errNoPasswordAuth {
+					lockout.record(1, err)
+					errs++
+					batchErr = err
+				} else {
+					lockout.record(1, nil)
+				}
+				continue
+			}
+			lockout.record(1, nil)
 			if err != nil {
-				failures++
+				//The server cut the connection, like sshd past its
+				//MaxAuthTries, so later connections stay under that
+				perConn = len(answered)
 			}
+			i += len(answered)
+			continue
 		}
+		cred := creds[i]
+		var result string
+		var prompts []string
+		err := batchErr
+		batchErr = nil
+		for retry := 0; ; retry++ {
+			//A failed batch was already charged as the first attempt
+			if retry > 0 || err == nil {
+				result, prompts, err = sshAuthAttempt(sr.hostport, cred.User, cred.Password, timeout, opts)
+				if mismatched() {
+					return true
+				}
+				conns++
+				//A host without a method to send the credential through
+				//answered, so it isn't charged as a failed connection
+				if ConnectionErrorType(err) == ConnErrAuthMethods {
+					lockout.record(1, nil)
+					break
+				}
+				lockout.record(1, err)
+				if err == nil {
+					break
+				}
+				errs++
+			}
+			if retry >= policy.retries || errs >= policy.maxErrors || !isTransientError(err) || !paced(1) {
+				break
+			}
+			if !policy.wait(ctx, retry) {
+				return false
+			}
+		}
+		res := BruteForceResult{
+			hostport: sr.hostport,
+			cred:     cred,
+			result:   result,
+			err:      err,
+			prompts:  prompts,
+		}
+		results <- res
+		i++
 	}
+	return true
 }
 
-func bruteForcer(numWorkers int, requests []ScanRequest, timeout time.Duration) chan BruteForceResult {
+//bruteForcer tries the credentials of requests using numWorkers workers.
+//Once ctx is canceled no more connections are started, attempts in progress
+//finish and the results channel is closed.
+func bruteForcer(ctx context.Context, numWorkers int, requests []ScanRequest, timeout time.Duration, policy retryPolicy) chan BruteForceResult {
 	var wg sync.WaitGroup
 
 	requestChan := make(chan ScanRequest, numWorkers)
 	go func() {
+		defer close(requestChan)
 		for _, sr := range requests {
-			requestChan <- sr
+			select {
+			case requestChan <- sr:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(requestChan)
 	}()
 	results := make(chan BruteForceResult, 1000)
 
 	for w := 0; w <= numWorkers; w++ {
 		wg.Add(1)
 		go func() {
-			bruteworker(requestChan, results, timeout)
+			bruteworker(ctx, requestChan, results, timeout, policy)
 			wg.Done()
 		}()
 	}
@@ -0,0 +1,120 @@
+package sshauditor
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Connection error types
+const (
+	ConnErrTimeout = "timeout"
+	ConnErrRefused = "connection refused"
+	//ConnErrReset covers connections reset or closed by the host
+	ConnErrReset     = "connection reset"
+	ConnErrHandshake = "handshake failure"
+	//ConnErrAlgorithms is a host without a key exchange, host key, cipher
+	//or MAC algorithm in common with the client
+	ConnErrAlgorithms = "unsupported algorithms"
+	//ConnErrNotSSH is a service that didn't send an ssh version banner
+	ConnErrNotSSH = "banner not ssh"
+	//ConnErrAuthMethods is a host that didn't offer any method the
+	//credential could be sent through
+	ConnErrAuthMethods = "auth method mismatch"
+	ConnErrOther       = "other"
+)
+
+//ConnectionError is an attempt to test a credential that failed without
+//saying whether the credential works
+type ConnectionError struct {
+	//Type is one of the ConnErrX constants
+	Type string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+	return e.Type + ": " + e.Err.Error()
+}
+
+//Cause returns the underlying error, for errors.Cause
+func (e *ConnectionError) Cause() error {
+	return e.Err
+}
+
+//classifyConnectionError wraps err in a ConnectionError of the matching
+//type.  The ssh client wraps most errors with fmt.Errorf, so they are
+//mostly told apart by their message.
+func classifyConnectionError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ConnectionError); ok {
+		return err
+	}
+	return &ConnectionError{Type: connectionErrorType(err), Err: err}
+}
+
+func connectionErrorType(err error) string {
+	msg := err.Error()
+	has := func(fragments ...string) bool {
+		for _, f := range fragments {
+			if strings.Contains(msg, f) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case err == errNoPasswordAuth || authMethodMismatch(err):
+		return ConnErrAuthMethods
+	case has("no common algorithm"):
+		return ConnErrAlgorithms
+	case has("overflow reading version string"):
+		return ConnErrNotSSH
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ConnErrTimeout
+	}
+	switch {
+	case has("i/o timeout"):
+		return ConnErrTimeout
+	case has("connection refused"):
+		return ConnErrRefused
+	case err == io.EOF || has("EOF", "connection reset", "broken pipe"):
+		return ConnErrReset
+	case has("handshake failed"):
+		return ConnErrHandshake
+	}
+	return ConnErrOther
+}
+
+//ConnectionErrorType returns the type of a ConnectionError, or ConnErrOther
+//for any other error
+func ConnectionErrorType(err error) string {
+	if ce, ok := err.(*ConnectionError); ok {
+		return ce.Type
+	}
+	return ConnErrOther
+}
+
+//authMethodMismatch returns true if err is an authentication failure where
+//no method but none was tried, because the host offered none of the
+//methods the credential could be sent through
+func authMethodMismatch(err error) bool {
+	if !isAuthFailure(err) {
+		return false
+	}
+	for _, m := range attemptedMethods(err) {
+		if m != AuthNone {
+			return false
+		}
+	}
+	return true
+}
+
+//connectionErrorDetail returns the message of err without its type
+func connectionErrorDetail(err error) string {
+	return errors.Cause(err).Error()
+}
@@ -0,0 +1,234 @@
+package sshauditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnectionErrorType(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("dial tcp 10.0.0.1:22: i/o timeout"), ConnErrTimeout},
+		{errors.New("ssh: handshake failed: read tcp 10.0.0.2:5000->10.0.0.1:22: i/o timeout"), ConnErrTimeout},
+		{errors.New("dial tcp 10.0.0.1:22: connect: connection refused"), ConnErrRefused},
+		{errors.New("ssh: handshake failed: read tcp 10.0.0.2:5000->10.0.0.1:22: read: connection reset by peer"), ConnErrReset},
+		{errors.New("ssh: handshake failed: EOF"), ConnErrReset},
+		{io.EOF, ConnErrReset},
+		{errors.New("ssh: handshake failed: ssh: no common algorithm for key exchange; client offered: [curve25519-sha256@libssh.org], server offered: [diffie-hellman-group1-sha1]"), ConnErrAlgorithms},
+		{errors.New("ssh: handshake failed: ssh: overflow reading version string"), ConnErrNotSSH},
+		{errors.New("ssh: handshake failed: host key does not match the discovered keys"), ConnErrHandshake},
+		{errors.New("ssh: unable to authenticate, attempted methods [none], no supported methods remain"), ConnErrAuthMethods},
+		{errNoPasswordAuth, ConnErrAuthMethods},
+		{errors.New("ssh: no key found"), ConnErrOther},
+	}
+	for _, tt := range tests {
+		err := classifyConnectionError(tt.err)
+		if got := ConnectionErrorType(err); got != tt.expected {
+			t.Errorf("ConnectionErrorType(%q) => %q, want %q", tt.err, got, tt.expected)
+		}
+		if detail := connectionErrorDetail(err); detail != tt.err.Error() {
+			t.Errorf("connectionErrorDetail(%q) => %q", tt.err, detail)
+		}
+	}
+	if classifyConnectionError(nil) != nil {
+		t.Errorf("classifyConnectionError(nil) != nil")
+	}
+	if !isTransientError(classifyConnectionError(io.EOF)) {
+		t.Errorf("isTransientError() => false for a classified EOF")
+	}
+}
+
+//listenRaw starts a tcp server on localhost that handles every connection
+//with serve, and returns its address
+func listenRaw(t *testing.T, serve func(net.Conn)) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestSSHAuthAttemptConnectionErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	silent, stop := listenRaw(t, func(c net.Conn) {
+		time.Sleep(2 * time.Second)
+		c.Close()
+	})
+	defer stop()
+	hangup, stop := listenRaw(t, func(c net.Conn) { c.Close() })
+	defer stop()
+	http, stop := listenRaw(t, func(c net.Conn) {
+		fmt.Fprintf(c, "HTTP/1.1 400 Bad Request\r\nContent-Type: text/html\r\n\r\n%s", strings.Repeat("x", 300))
+		c.Close()
+	})
+	defer stop()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(newTestSigners(t)[2])
+	publicKeyOnly, stop := listenSSHConfig(t, config)
+	defer stop()
+
+	tests := []struct {
+		name     string
+		hostport string
+		expected string
+	}{
+		{"closed port", closed, ConnErrRefused},
+		{"no banner", silent, ConnErrTimeout},
+		{"hangup", hangup, ConnErrReset},
+		{"http", http, ConnErrNotSSH},
+		{"publickey only", publicKeyOnly, ConnErrAuthMethods},
+	}
+	for _, tt := range tests {
+		result, err := SSHAuthAttempt(tt.hostport, "root", "root", 200*time.Millisecond)
+		if err == nil {
+			t.Errorf("%s: SSHAuthAttempt() => %q, want a %s error", tt.name, result, tt.expected)
+			continue
+		}
+		if got := ConnectionErrorType(err); got != tt.expected {
+			t.Errorf("%s: SSHAuthAttempt() => %v, want a %s error", tt.name, err, tt.expected)
+		}
+	}
+	//The probe for hosts accepting anything classifies its errors too
+	if _, err := SSHAcceptsAnything(closed, 200*time.Millisecond); ConnectionErrorType(err) != ConnErrRefused {
+		t.Errorf("SSHAcceptsAnything() => %v, want a %s error", err, ConnErrRefused)
+	}
+	//A password the host turned down is a negative result, not an error
+	rejecting, _, stop := listenSSHPasswords(t, 3, nil)
+	defer stop()
+	if result, err := SSHAuthAttempt(rejecting, "root", "root", time.Second); result != "" || err != nil {
+		t.Errorf("rejected password: SSHAuthAttempt() => %q, %v", result, err)
+	}
+}
+
+func TestAuthMethodMismatch(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none], no supported methods remain"), true},
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain"), false},
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [keyboard-interactive none], no supported methods remain"), false},
+		{errors.New("dial tcp 10.0.0.1:22: connect: connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := authMethodMismatch(tt.err); got != tt.expected {
+			t.Errorf("authMethodMismatch(%q) => %v, want %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestBruteHostAuthMethodMismatch(t *testing.T) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(newTestSigners(t)[2])
+	hostport, stop := listenSSHConfig(t, config)
+	defer stop()
+	sr := ScanRequest{hostport: hostport}
+	for _, p := range []string{"a", "b", "c"} {
+		sr.credentials = append(sr.credentials, Credential{User: "root", Password: p})
+	}
+	results := make(chan BruteForceResult, 20)
+	bruteHost(context.Background(), sr, results, time.Second, retryPolicy{maxErrors: 1, backoff: time.Millisecond})
+	close(results)
+	//Every credential is stored as a mismatch, none of them use up the
+	//host's error budget
+	n := 0
+	for br := range results {
+		if br.done {
+			continue
+		}
+		n++
+		if br.deferred != "" || ConnectionErrorType(br.err) != ConnErrAuthMethods {
+			t.Errorf("bruteHost() => %+v, want an auth method mismatch", br)
+		}
+	}
+	if n != 3 {
+		t.Errorf("bruteHost() => %d results, want 3", n)
+	}
+}
+
+func TestHostErrors(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	check(s.Init())
+	for _, c := range []Credential{{User: "root", Password: "root"}, {User: "admin", Password: "admin"}, {User: "guest", Password: "guest"}} {
+		c.ScanInterval = 14
+		_, err = s.AddCredential(c)
+		check(err)
+	}
+	for _, h := range []string{"192.168.1.1:22", "192.168.1.2:22", "192.168.1.3:22"} {
+		check(s.addOrUpdateHost(SSHHost{hostport: h, keyfp: "a"}))
+	}
+	_, err = s.initHostCreds()
+	check(err)
+
+	refused := classifyConnectionError(errors.New("dial tcp 192.168.1.2:22: connect: connection refused"))
+	timeout := classifyConnectionError(errors.New("dial tcp 192.168.1.2:22: i/o timeout"))
+	for _, br := range []BruteForceResult{
+		{hostport: "192.168.1.1:22", cred: Credential{User: "root", Password: "root"}},
+		{hostport: "192.168.1.1:22", cred: Credential{User: "admin", Password: "admin"}},
+		{hostport: "192.168.1.2:22", cred: Credential{User: "root", Password: "root"}, err: refused},
+		{hostport: "192.168.1.2:22", cred: Credential{User: "root", Password: "root"}, err: refused},
+		{hostport: "192.168.1.2:22", cred: Credential{User: "admin", Password: "admin"}},
+		{hostport: "192.168.1.2:22", cred: Credential{User: "guest", Password: "guest"}, err: timeout},
+		//A credential that worked once but then errored is still failing
+		{hostport: "192.168.1.1:22", cred: Credential{User: "admin", Password: "admin"}, err: refused},
+		{hostport: "192.168.1.1:22", cred: Credential{User: "admin", Password: "admin"}},
+	} {
+		check(s.updateBruteResult(1, br))
+	}
+	//The refused error is the newest, even though its message sorts first
+	_, err = s.Exec(`UPDATE host_creds SET last_error_at='2999-01-01 00:00:00' WHERE hostport='192.168.1.2:22' AND "user"='root'`)
+	check(err)
+	hosts, err := s.GetHostErrors()
+	check(err)
+	if len(hosts) != 2 {
+		t.Fatalf("GetHostErrors() => %+v, want 2 hosts", hosts)
+	}
+	failing, untested := hosts[0], hosts[1]
+	if failing.Hostport != "192.168.1.2:22" || failing.Tested != 1 || failing.Failing != 2 ||
+		failing.Errors[ConnErrRefused] != 1 || failing.Errors[ConnErrTimeout] != 1 ||
+		failing.MaxErrorCount != 2 || !strings.Contains(failing.LastError, "refused") {
+		t.Errorf("GetHostErrors() => %+v for the failing host", failing)
+	}
+	if untested.Hostport != "192.168.1.3:22" || untested.Credentials != 3 || untested.Tested != 0 || untested.Failing != 0 {
+		t.Errorf("GetHostErrors() => %+v for the untested host", untested)
+	}
+}
@@ -0,0 +1,175 @@
+package sshauditor
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Credential rule types
+const (
+	RuleCIDR   = "cidr"
+	RulePort   = "port"
+	RuleBanner = "banner"
+	RuleAuth   = "auth"
+)
+
+//CredentialRule restricts the hosts a credential is tried against.  A
+//credential is only tried against hosts matched by one of its rules of each
+//type it has rules of, and against every host if it has no rules.
+type CredentialRule struct {
+	//Type is one of the RuleX constants
+	Type string
+	//Pattern is a network or address for RuleCIDR, a port specification
+	//like "22,2200-2299" for RulePort, a regular expression matched against
+	//the ssh banner for RuleBanner and an authentication method the host
+	//must advertise for RuleAuth
+	Pattern string
+}
+
+func (r CredentialRule) String() string {
+	return r.Type + "=" + r.Pattern
+}
+
+//ParseCredentialRule parses and validates a rule in the form type=pattern,
+//like "cidr=10.0.0.0/8" or "banner=^SSH-2.0-Cisco"
+func ParseCredentialRule(spec string) (CredentialRule, error) {
+	i := strings.IndexByte(spec, '=')
+	if i == -1 {
+		return CredentialRule{}, fmt.Errorf("invalid credential rule %q: expected type=pattern", spec)
+	}
+	r := CredentialRule{Type: strings.TrimSpace(spec[:i]), Pattern: strings.TrimSpace(spec[i+1:])}
+	if _, err := newCredentialMatcher([]CredentialRule{r}); err != nil {
+		return CredentialRule{}, err
+	}
+	return r, nil
+}
+
+//JoinCredentialRules returns rules as stored in Credential.Rules
+func JoinCredentialRules(rules []CredentialRule) string {
+	lines := make([]string, len(rules))
+	for i, r := range rules {
+		lines[i] = r.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+//MatchRules returns the rules restricting the hosts the credential is tried
+//against
+func (c Credential) MatchRules() ([]CredentialRule, error) {
+	var rules []CredentialRule
+	for _, line := range strings.Split(c.Rules, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		r, err := ParseCredentialRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+//credentialMatcher is the compiled form of a credential's rules
+type credentialMatcher struct {
+	nets    []*net.IPNet
+	ports   map[int]bool
+	banners []*regexp.Regexp
+	auth    []string
+}
+
+func newCredentialMatcher(rules []CredentialRule) (*credentialMatcher, error) {
+	m := &credentialMatcher{}
+	for _, r := range rules {
+		switch r.Type {
+		case RuleCIDR:
+			_, network, err := net.ParseCIDR(r.Pattern)
+			if err != nil {
+				ip := net.ParseIP(r.Pattern)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid credential rule %s: not a network or address", r)
+				}
+				network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+			}
+			m.nets = append(m.nets, network)
+		case RulePort:
+			ports, err := ParsePorts([]string{r.Pattern})
+			if err != nil || len(ports) == 0 {
+				return nil, fmt.Errorf("invalid credential rule %s: %v", r, err)
+			}
+			if m.ports == nil {
+				m.ports = make(map[int]bool)
+			}
+			for _, p := range ports {
+				m.ports[p] = true
+			}
+		case RuleBanner:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credential rule %s: %v", r, err)
+			}
+			m.banners = append(m.banners, re)
+		case RuleAuth:
+			switch r.Pattern {
+			case AuthNone, AuthPassword, AuthPublicKey, AuthKeyboardInteractive, AuthGSSAPI:
+			default:
+				return nil, fmt.Errorf("invalid credential rule %s: unknown authentication method", r)
+			}
+			m.auth = append(m.auth, r.Pattern)
+		default:
+			return nil, fmt.Errorf("invalid credential rule %s: type must be one of %s, %s, %s or %s",
+				r, RuleCIDR, RulePort, RuleBanner, RuleAuth)
+		}
+	}
+	return m, nil
+}
+
+//matches returns true if the credential should be tried against h.  Auth
+//rules match hosts whose advertised methods are unknown.
+func (m *credentialMatcher) matches(h Host) bool {
+	host, port, err := net.SplitHostPort(h.Hostport)
+	if err != nil {
+		host = h.Hostport
+	}
+	if len(m.nets) != 0 {
+		ip := net.ParseIP(host)
+		found := false
+		for _, n := range m.nets {
+			if ip != nil && n.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.ports) != 0 {
+		p, _ := strconv.Atoi(port)
+		if !m.ports[p] {
+			return false
+		}
+	}
+	if len(m.banners) != 0 {
+		found := false
+		for _, re := range m.banners {
+			if re.MatchString(h.Version) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.auth) != 0 {
+		methods := h.SupportedAuthMethods()
+		if len(methods) != 0 && !containsAny(methods, m.auth) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package sshauditor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCredentialRule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"cidr=10.0.0.0/8", true},
+		{"cidr=192.168.1.1", true},
+		{"cidr=2001:db8::/32", true},
+		{"port=22,2200-2299", true},
+		{"banner=(?i)cisco", true},
+		{"auth=keyboard-interactive", true},
+		{"cidr=10.0.0.0/33", false},
+		{"port=0", false},
+		{"banner=(", false},
+		{"auth=kerberos", false},
+		{"os=linux", false},
+		{"banner", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseCredentialRule(tt.spec)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseCredentialRule(%q) => %v, want valid=%v", tt.spec, err, tt.valid)
+		}
+	}
+}
+
+func TestCredentialMatchRules(t *testing.T) {
+	c := Credential{Rules: "banner=Cisco\n\nport = 22"}
+	rules, err := c.MatchRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CredentialRule{{RuleBanner, "Cisco"}, {RulePort, "22"}}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("MatchRules() => %v, want %v", rules, expected)
+	}
+	if joined := JoinCredentialRules(rules); joined != "banner=Cisco\nport=22" {
+		t.Errorf("JoinCredentialRules() => %q", joined)
+	}
+}
+
+func TestCredentialMatcher(t *testing.T) {
+	cisco := Host{Hostport: "10.1.2.3:22", Version: "SSH-2.0-Cisco-1.25"}
+	ubuntu := Host{Hostport: "10.2.0.1:2222", Version: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10",
+		AuthMethods: "publickey"}
+	v6 := Host{Hostport: "[2001:db8::1]:22", Version: "SSH-2.0-OpenSSH_9.6"}
+	tests := []struct {
+		name     string
+		rules    []string
+		expected []bool
+	}{
+		{"no rules", nil, []bool{true, true, true}},
+		{"cidr", []string{"cidr=10.1.0.0/16"}, []bool{true, false, false}},
+		{"either cidr", []string{"cidr=10.1.0.0/16", "cidr=10.2.0.1"}, []bool{true, true, false}},
+		{"ipv6", []string{"cidr=2001:db8::/32"}, []bool{false, false, true}},
+		{"port", []string{"port=2200-2299"}, []bool{false, true, false}},
+		{"banner", []string{"banner=(?i)cisco"}, []bool{true, false, false}},
+		{"banner and cidr", []string{"banner=OpenSSH", "cidr=10.0.0.0/8"}, []bool{false, true, false}},
+		{"auth unknown or advertised", []string{"auth=password"}, []bool{true, false, true}},
+	}
+	for _, tt := range tests {
+		var rules []CredentialRule
+		for _, spec := range tt.rules {
+			r, err := ParseCredentialRule(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules = append(rules, r)
+		}
+		m, err := newCredentialMatcher(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, h := range []Host{cisco, ubuntu, v6} {
+			if got := m.matches(h); got != tt.expected[i] {
+				t.Errorf("%s: matches(%s) => %v, want %v", tt.name, h.Hostport, got, tt.expected[i])
+			}
+		}
+	}
+}
+
+func TestInitHostCredsRules(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	check(s.Init())
+	_, err = s.AddCredential(Credential{User: "root", Password: "root", ScanInterval: 14})
+	check(err)
+	cisco := Credential{User: "cisco", Password: "cisco", ScanInterval: 14, Rules: "banner=Cisco"}
+	_, err = s.AddCredential(cisco)
+	check(err)
+	for _, h := range []SSHHost{
+		{hostport: "192.168.1.1:22", version: "SSH-2.0-Cisco-1.25", keyfp: "a"},
+		{hostport: "192.168.1.2:22", version: "SSH-2.0-OpenSSH_9.6", keyfp: "b"},
+	} {
+		check(s.addOrUpdateHost(h))
+	}
+	queued := func() map[string]int {
+		_, err := s.initHostCreds()
+		check(err)
+		queue, err := s.getScanQueue()
+		check(err)
+		counts := make(map[string]int)
+		for _, sr := range queue {
+			counts[sr.hostport] = len(sr.credentials)
+		}
+		return counts
+	}
+	expected := map[string]int{"192.168.1.1:22": 2, "192.168.1.2:22": 1}
+	if counts := queued(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("credentials per host => %v, want %v", counts, expected)
+	}
+
+	//Narrowing the rules drops pairs that were already queued
+	cisco.Rules = "banner=Cisco\ncidr=10.0.0.0/8"
+	_, err = s.AddCredential(cisco)
+	check(err)
+	expected = map[string]int{"192.168.1.1:22": 1, "192.168.1.2:22": 1}
+	if counts := queued(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("credentials per host after narrowing => %v, want %v", counts, expected)
+	}
+
+	if _, err := s.AddCredential(Credential{User: "x", Password: "x", Rules: "os=linux"}); err == nil {
+		t.Errorf("AddCredential accepted an invalid rule")
+	}
+}
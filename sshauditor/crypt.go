@@ -0,0 +1,102 @@
+package sshauditor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+//encryptedPrefix is prepended to every encrypted password.  Whether a
+//password is encrypted is decided by the encryption table, not this prefix,
+//since a plaintext password may start with it too.
+const encryptedPrefix = "enc:v1:"
+
+//verifierPlaintext is encrypted with the key and stored alongside the salt
+//so that a wrong passphrase can be detected before anything is decrypted
+const verifierPlaintext = "ssh-auditor"
+
+//ErrWrongPassphrase is returned when unlocking a store with a passphrase
+//that does not match the one it was encrypted with
+var ErrWrongPassphrase = errors.New("wrong passphrase for encrypted database")
+
+//ErrPassphraseRequired is returned when an encrypted password is read from a
+//store that has not been unlocked
+var ErrPassphraseRequired = errors.New("database is encrypted, a passphrase is required")
+
+//secretCrypter encrypts passwords and private keys before they are written
+//to the store.  Encryption is deterministic: the GCM nonce is derived from an
+//HMAC of the plaintext, so the same password always encrypts to the same
+//value and existing primary keys and equality lookups keep working.  This
+//reveals which rows share a password, but nothing about the password itself.
+type secretCrypter struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	return base64.StdEncoding.EncodeToString(salt), err
+}
+
+//newSecretCrypter derives the encryption and nonce keys from passphrase
+func newSecretCrypter(passphrase []byte, salt string) (*secretCrypter, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid salt")
+	}
+	key, err := scrypt.Key(passphrase, rawSalt, 1<<15, 8, 1, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretCrypter{aead: aead, macKey: key[32:]}, nil
+}
+
+func (c *secretCrypter) encrypt(plaintext string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix)
+}
+
+func (c *secretCrypter) decrypt(s string) (string, error) {
+	if !isEncrypted(s) {
+		return "", errors.New("decrypt: value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encryptedPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "decrypt")
+	}
+	ns := c.aead.NonceSize()
+	if len(sealed) < ns {
+		return "", errors.New("decrypt: value too short")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:ns], sealed[ns:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypt")
+	}
+	return string(plaintext), nil
+}
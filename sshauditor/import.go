@@ -0,0 +1,271 @@
+package sshauditor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//DiscoveredPort is an open port found by an external scanner such as nmap or
+//masscan
+type DiscoveredPort struct {
+	Address string
+	Port    int
+	Service string
+	Banner  string
+	//Names are hostnames the scanner was given for the address
+	Names []string
+}
+
+func (d DiscoveredPort) hostport() string {
+	return net.JoinHostPort(d.Address, strconv.Itoa(d.Port))
+}
+
+//isSSH returns true if the port looks like it is running ssh, either by the
+//scanner's service detection, the banner, or being one of ports
+func (d DiscoveredPort) isSSH(ports []int) bool {
+	if d.Service == "ssh" || strings.HasPrefix(d.Banner, "SSH-") {
+		return true
+	}
+	for _, p := range ports {
+		if d.Port == p {
+			return true
+		}
+	}
+	return false
+}
+
+//nmapRun is the subset of nmap's XML output that is needed.  masscan -oX
+//writes the same structure, with banners as a service attribute.
+type nmapRun struct {
+	Hosts []struct {
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"hostnames>hostname"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name   string `xml:"name,attr"`
+				Banner string `xml:"banner,attr"`
+			} `xml:"service"`
+			Scripts []struct {
+				ID     string `xml:"id,attr"`
+				Output string `xml:"output,attr"`
+			} `xml:"script"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+//ParseNmapXML returns the open TCP ports in nmap XML output (nmap -oX).
+//Banners are taken from the banner script (--script banner) when it was run.
+func ParseNmapXML(r io.Reader) ([]DiscoveredPort, error) {
+	var run nmapRun
+	err := xml.NewDecoder(r).Decode(&run)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse nmap XML: %s", err)
+	}
+	var found []DiscoveredPort
+	for _, h := range run.Hosts {
+		var addr string
+		for _, a := range h.Addresses {
+			if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+				addr = a.Addr
+				break
+			}
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		var names []string
+		for _, n := range h.Hostnames {
+			if n.Type == "user" {
+				names = append(names, n.Name)
+			}
+		}
+		for _, p := range h.Ports {
+			if p.Protocol != "tcp" || p.State.State != "open" {
+				continue
+			}
+			banner := p.Service.Banner
+			for _, s := range p.Scripts {
+				if s.ID == "banner" {
+					banner = s.Output
+				}
+			}
+			found = append(found, DiscoveredPort{
+				Address: ip.String(),
+				Port:    p.PortID,
+				Service: p.Service.Name,
+				Banner:  cleanBanner(banner),
+				Names:   names,
+			})
+		}
+	}
+	return found, nil
+}
+
+//masscanRecord is one entry of masscan's JSON output (masscan -oJ)
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port    int    `json:"port"`
+		Proto   string `json:"proto"`
+		Status  string `json:"status"`
+		Service struct {
+			Name   string `json:"name"`
+			Banner string `json:"banner"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+//ParseMasscan returns the open TCP ports in masscan output.  The list (-oL),
+//JSON (-oJ) and XML (-oX) formats are recognised.  masscan reports banners
+//separately from open ports, so they are merged by address and port.
+func ParseMasscan(r io.Reader) ([]DiscoveredPort, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var found []DiscoveredPort
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		found, err = ParseNmapXML(bytes.NewReader(data))
+	case bytes.HasPrefix(trimmed, []byte("[")), bytes.HasPrefix(trimmed, []byte("{")):
+		found, err = parseMasscanJSON(data)
+	default:
+		found, err = parseMasscanList(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mergeDiscoveredPorts(found), nil
+}
+
+//parseMasscanJSON parses masscan's JSON output one record per line, since
+//some versions leave a trailing comma after the last record
+func parseMasscanJSON(data []byte) ([]DiscoveredPort, error) {
+	var found []DiscoveredPort
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimSuffix(line, ",")
+		if line == "" || line == "[" || line == "]" {
+			continue
+		}
+		var rec masscanRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("unable to parse masscan JSON: %s", err)
+		}
+		ip := net.ParseIP(rec.IP)
+		if ip == nil {
+			continue
+		}
+		for _, p := range rec.Ports {
+			if p.Proto != "tcp" || (p.Status != "open" && p.Service.Banner == "") {
+				continue
+			}
+			found = append(found, DiscoveredPort{
+				Address: ip.String(),
+				Port:    p.Port,
+				Service: p.Service.Name,
+				Banner:  cleanBanner(p.Service.Banner),
+			})
+		}
+	}
+	return found, scanner.Err()
+}
+
+//parseMasscanList parses masscan's list output, lines of
+//
+//	open tcp 22 192.0.2.1 1580000000
+//	banner tcp 22 192.0.2.1 1580000000 ssh SSH-2.0-OpenSSH_7.4
+func parseMasscanList(data []byte) ([]DiscoveredPort, error) {
+	var found []DiscoveredPort
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[1] != "tcp" {
+			continue
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse masscan output line %q", line)
+		}
+		ip := net.ParseIP(fields[3])
+		if ip == nil {
+			return nil, fmt.Errorf("unable to parse masscan output line %q", line)
+		}
+		d := DiscoveredPort{Address: ip.String(), Port: port}
+		switch fields[0] {
+		case "open":
+		case "banner":
+			if len(fields) < 6 {
+				continue
+			}
+			d.Service = fields[5]
+			d.Banner = cleanBanner(strings.Join(fields[6:], " "))
+		default:
+			continue
+		}
+		found = append(found, d)
+	}
+	return found, scanner.Err()
+}
+
+//mergeDiscoveredPorts combines entries for the same address and port,
+//keeping the first order they appeared in
+func mergeDiscoveredPorts(found []DiscoveredPort) []DiscoveredPort {
+	var merged []DiscoveredPort
+	idx := make(map[string]int)
+	for _, d := range found {
+		i, seen := idx[d.hostport()]
+		if !seen {
+			idx[d.hostport()] = len(merged)
+			merged = append(merged, d)
+			continue
+		}
+		if merged[i].Service == "" {
+			merged[i].Service = d.Service
+		}
+		if merged[i].Banner == "" {
+			merged[i].Banner = d.Banner
+		}
+	}
+	return merged
+}
+
+//bannerEscapes undoes the escaping of line endings scanners apply to banners
+var bannerEscapes = strings.NewReplacer(`\x0d`, "\r", `\x0D`, "\r", `\x0a`, "\n", `\x0A`, "\n")
+
+//cleanBanner returns the first line of a captured banner, the part
+//ScanPort would have returned
+func cleanBanner(banner string) string {
+	banner = strings.TrimSpace(bannerEscapes.Replace(banner))
+	if i := strings.IndexAny(banner, "\r\n"); i != -1 {
+		banner = banner[:i]
+	}
+	return banner
+}
@@ -0,0 +1,142 @@
+package sshauditor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNmapXML = `<?xml version="1.0" encoding="UTF-8"?>
+<nmaprun scanner="nmap" args="nmap -sV --script banner -oX - 192.0.2.0/30">
+<host><status state="up" reason="syn-ack"/>
+<address addr="192.0.2.1" addrtype="ipv4"/>
+<address addr="00:11:22:33:44:55" addrtype="mac"/>
+<hostnames><hostname name="bastion.example.com" type="user"/><hostname name="web1.example.com" type="PTR"/></hostnames>
+<ports>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/><service name="ssh" product="OpenSSH"/><script id="banner" output="SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1\x0D\x0A"/></port>
+<port protocol="tcp" portid="80"><state state="open" reason="syn-ack"/><service name="http"/></port>
+<port protocol="tcp" portid="2222"><state state="filtered" reason="no-response"/><service name="EtherNetIP-1"/></port>
+</ports>
+</host>
+<host><status state="up" reason="syn-ack"/>
+<address addr="2001:db8::2" addrtype="ipv6"/>
+<ports>
+<port protocol="tcp" portid="2222"><state state="open" reason="syn-ack"/><service name="ssh"/></port>
+</ports>
+</host>
+</nmaprun>
+`
+
+func TestParseNmapXML(t *testing.T) {
+	found, err := ParseNmapXML(strings.NewReader(testNmapXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DiscoveredPort{
+		{Address: "192.0.2.1", Port: 22, Service: "ssh", Banner: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1", Names: []string{"bastion.example.com"}},
+		{Address: "192.0.2.1", Port: 80, Service: "http", Names: []string{"bastion.example.com"}},
+		{Address: "2001:db8::2", Port: 2222, Service: "ssh"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("ParseNmapXML() =>\n%#v\nwant\n%#v", found, expected)
+	}
+	if _, err := ParseNmapXML(strings.NewReader("not xml")); err == nil {
+		t.Errorf("ParseNmapXML() did not return an error for garbage")
+	}
+}
+
+func TestParseMasscan(t *testing.T) {
+	expected := []DiscoveredPort{
+		{Address: "192.0.2.1", Port: 22, Service: "ssh", Banner: "SSH-2.0-OpenSSH_7.4"},
+		{Address: "192.0.2.2", Port: 2222},
+	}
+	cases := map[string]string{
+		"list": `#masscan
+open tcp 22 192.0.2.1 1580000000
+open tcp 2222 192.0.2.2 1580000000
+banner tcp 22 192.0.2.1 1580000001 ssh SSH-2.0-OpenSSH_7.4
+# end
+`,
+		"json": `[
+{   "ip": "192.0.2.1",   "timestamp": "1580000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.2",   "timestamp": "1580000000", "ports": [ {"port": 2222, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.1",   "timestamp": "1580000001", "ports": [ {"port": 22, "proto": "tcp", "service": {"name": "ssh", "banner": "SSH-2.0-OpenSSH_7.4"} } ] },
+]
+`,
+		"xml": `<?xml version="1.0"?>
+<nmaprun scanner="masscan">
+<host endtime="1580000000"><address addr="192.0.2.1" addrtype="ipv4"/><ports><port protocol="tcp" portid="22"><state state="open" reason="syn-ack" reason_ttl="64"/></port></ports></host>
+<host endtime="1580000000"><address addr="192.0.2.2" addrtype="ipv4"/><ports><port protocol="tcp" portid="2222"><state state="open" reason="syn-ack" reason_ttl="64"/></port></ports></host>
+<host endtime="1580000001"><address addr="192.0.2.1" addrtype="ipv4"/><ports><port protocol="tcp" portid="22"><state state="open"/><service name="ssh" banner="SSH-2.0-OpenSSH_7.4"></service></port></ports></host>
+</nmaprun>
+`,
+	}
+	for format, output := range cases {
+		found, err := ParseMasscan(strings.NewReader(output))
+		if err != nil {
+			t.Errorf("ParseMasscan(%s) => %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("ParseMasscan(%s) =>\n%#v\nwant\n%#v", format, found, expected)
+		}
+	}
+}
+
+func TestDiscoverImported(t *testing.T) {
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	port, stop := listenBanner(t)
+	defer stop()
+	otherPort, stopOther := listenBanner(t)
+	defer stopOther()
+
+	found := []DiscoveredPort{
+		//Banner already captured by the scanner
+		{Address: "127.0.0.1", Port: port, Service: "ssh", Banner: "SSH-2.0-Imported", Names: []string{"local.example.com"}},
+		//Banner needs fetching, ssh because of the port list
+		{Address: "127.0.0.1", Port: otherPort},
+		//Not ssh
+		{Address: "127.0.0.1", Port: 1, Service: "http"},
+		//Excluded
+		{Address: "127.0.0.2", Port: port, Service: "ssh"},
+	}
+	auditor := New(s)
+	auditor.SetResolver(testResolver)
+	err := auditor.DiscoverImported(context.Background(), "test", ScanConfiguration{
+		Ports:       []int{otherPort},
+		Exclude:     []string{"127.0.0.2"},
+		Concurrency: 1,
+	}, found)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := s.GetActiveHosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make(map[string]string)
+	for _, h := range hosts {
+		versions[h.Hostport] = h.Version
+	}
+	expected := map[string]string{
+		fmt.Sprintf("127.0.0.1:%d", port):      "SSH-2.0-Imported",
+		fmt.Sprintf("127.0.0.1:%d", otherPort): "SSH-2.0-Test",
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("imported hosts => %v, want %v", versions, expected)
+	}
+	runs, err := s.GetScanRuns(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Type != "import" || runs[0].NewCount != 2 {
+		t.Errorf("unexpected scan run %#v", runs)
+	}
+}
@@ -0,0 +1,152 @@
+package sshauditor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Support statuses of a VersionGroup
+const (
+	StatusSupported = "supported"
+	StatusEndOfLife = "end of life"
+	StatusUnknown   = "unknown"
+)
+
+//releaseSupport is the version of a product shipped by a distribution
+//release and the date standard security support for the release ends.
+//Extended support like Ubuntu ESM or Debian LTS is not taken into account.
+type releaseSupport struct {
+	Product   string
+	Version   string
+	Distro    string
+	Release   string
+	EndOfLife string
+}
+
+//releaseSupportTable lists the ssh servers shipped by distribution releases.
+//Only Ubuntu and Debian are covered: RHEL and CentOS don't name themselves
+//in the banner and FreeBSD updates OpenSSH within a release, so versions on
+//those are reported as unknown along with the reason.
+var releaseSupportTable = []releaseSupport{
+	{"OpenSSH", "6.6p1", "Ubuntu", "14.04", "2019-04-30"},
+	{"OpenSSH", "6.6.1p1", "Ubuntu", "14.04", "2019-04-30"},
+	{"OpenSSH", "7.2p2", "Ubuntu", "16.04", "2021-04-30"},
+	{"OpenSSH", "7.6p1", "Ubuntu", "18.04", "2023-05-31"},
+	{"OpenSSH", "8.2p1", "Ubuntu", "20.04", "2025-05-31"},
+	{"OpenSSH", "8.9p1", "Ubuntu", "22.04", "2027-06-01"},
+	{"OpenSSH", "9.6p1", "Ubuntu", "24.04", "2029-05-31"},
+	{"OpenSSH", "6.0p1", "Debian", "7", "2016-04-25"},
+	{"OpenSSH", "6.7p1", "Debian", "8", "2018-06-17"},
+	{"OpenSSH", "7.4p1", "Debian", "9", "2020-07-06"},
+	{"OpenSSH", "7.9p1", "Debian", "10", "2022-09-10"},
+	{"OpenSSH", "8.4p1", "Debian", "11", "2024-08-14"},
+	{"OpenSSH", "9.2p1", "Debian", "12", "2026-06-10"},
+	{"OpenSSH", "10.0p1", "Debian", "13", "2028-08-09"},
+}
+
+//VersionGroup is the active hosts running a version of some software
+type VersionGroup struct {
+	Product string
+	Version string
+	Distro  string
+	//Release is the distribution release that ships this version
+	Release string
+	Status  string
+	//StatusReason explains why the status is unknown
+	StatusReason string
+	EndOfLife    string
+	Count        int
+	SeenFirst    string
+	SeenLast     string
+	Hosts        []Host
+}
+
+//supportStatus fills in the release, end of life date and status of g
+func (g *VersionGroup) supportStatus(now time.Time) {
+	g.Status = StatusUnknown
+	for _, r := range releaseSupportTable {
+		if r.Product != g.Product || r.Version != g.Version || r.Distro != g.Distro {
+			continue
+		}
+		g.Release = r.Release
+		g.EndOfLife = r.EndOfLife
+		g.Status = StatusSupported
+		if now.Format("2006-01-02") > r.EndOfLife {
+			g.Status = StatusEndOfLife
+		}
+		return
+	}
+	g.StatusReason = g.unknownReason()
+}
+
+//unknownReason explains why g is not in releaseSupportTable
+func (g *VersionGroup) unknownReason() string {
+	if g.Distro == "" {
+		return "distribution not named in the banner"
+	}
+	for _, r := range releaseSupportTable {
+		if r.Distro == g.Distro {
+			return fmt.Sprintf("%s %s is not shipped by a known %s release", g.Product, g.Version, g.Distro)
+		}
+	}
+	return fmt.Sprintf("no end of life data for %s", g.Distro)
+}
+
+//groupVersions groups hosts by software, version and distribution, sorted
+//by product and version
+func groupVersions(hosts []Host, now time.Time) []VersionGroup {
+	var groups []VersionGroup
+	idx := make(map[Software]int)
+	for _, h := range hosts {
+		sw := ParseBanner(h.Version)
+		key := Software{Product: sw.Product, Version: sw.Version, Distro: sw.Distro}
+		i, seen := idx[key]
+		if !seen {
+			i = len(groups)
+			idx[key] = i
+			groups = append(groups, VersionGroup{
+				Product:   sw.Product,
+				Version:   sw.Version,
+				Distro:    sw.Distro,
+				SeenFirst: h.SeenFirst,
+				SeenLast:  h.SeenLast,
+			})
+		}
+		g := &groups[i]
+		g.Count++
+		g.Hosts = append(g.Hosts, h)
+		if h.SeenFirst < g.SeenFirst {
+			g.SeenFirst = h.SeenFirst
+		}
+		if h.SeenLast > g.SeenLast {
+			g.SeenLast = h.SeenLast
+		}
+	}
+	for i := range groups {
+		groups[i].supportStatus(now)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.Product != b.Product {
+			return a.Product < b.Product
+		}
+		if c := compareVersions(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		return a.Distro < b.Distro
+	})
+	return groups
+}
+
+//VersionInventory groups the hosts seen at most maxAgeDays ago by the
+//software and version they run
+func (a *SSHAuditor) VersionInventory(maxAgeDays int) ([]VersionGroup, error) {
+	hosts, err := a.store.GetActiveHosts(maxAgeDays)
+	if err != nil {
+		return nil, errors.Wrap(err, "VersionInventory")
+	}
+	return groupVersions(hosts, time.Now()), nil
+}
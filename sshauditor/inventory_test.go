@@ -0,0 +1,44 @@
+package sshauditor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupVersions(t *testing.T) {
+	hosts := []Host{
+		{Hostport: "10.0.0.1:22", Version: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10", SeenFirst: "2024-01-02 00:00:00", SeenLast: "2026-10-01 00:00:00"},
+		{Hostport: "10.0.0.2:22", Version: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.13", SeenFirst: "2023-05-01 00:00:00", SeenLast: "2026-09-01 00:00:00"},
+		{Hostport: "10.0.0.3:22", Version: "SSH-2.0-OpenSSH_7.4p1 Debian-10+deb9u7", SeenFirst: "2020-01-01 00:00:00", SeenLast: "2026-10-02 00:00:00"},
+		{Hostport: "10.0.0.4:22", Version: "SSH-2.0-dropbear_2020.81", SeenFirst: "2025-01-01 00:00:00", SeenLast: "2026-10-03 00:00:00"},
+		{Hostport: "10.0.0.5:22", Version: "SSH-2.0-OpenSSH_10.0p2 Debian-7", SeenFirst: "2025-09-01 00:00:00", SeenLast: "2026-10-03 00:00:00"},
+		{Hostport: "10.0.0.6:22", Version: "SSH-2.0-OpenSSH_9.7 FreeBSD-20240806", SeenFirst: "2025-09-01 00:00:00", SeenLast: "2026-10-03 00:00:00"},
+	}
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	groups := groupVersions(hosts, now)
+
+	type summary struct {
+		Product, Version, Distro, Status, StatusReason string
+		Count                                          int
+	}
+	expected := []summary{
+		{"Dropbear", "2020.81", "", StatusUnknown, "distribution not named in the banner", 1},
+		{"OpenSSH", "7.4p1", "Debian", StatusEndOfLife, "", 1},
+		{"OpenSSH", "8.9p1", "Ubuntu", StatusSupported, "", 2},
+		{"OpenSSH", "9.7", "FreeBSD", StatusUnknown, "no end of life data for FreeBSD", 1},
+		{"OpenSSH", "10.0p2", "Debian", StatusUnknown, "OpenSSH 10.0p2 is not shipped by a known Debian release", 1},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("groupVersions() => %#v", groups)
+	}
+	for i, g := range groups {
+		got := summary{g.Product, g.Version, g.Distro, g.Status, g.StatusReason, g.Count}
+		if got != expected[i] {
+			t.Errorf("group %d = %v, want %v", i, got, expected[i])
+		}
+	}
+	ubuntu := groups[2]
+	if ubuntu.Release != "22.04" || ubuntu.SeenFirst != "2023-05-01 00:00:00" || ubuntu.SeenLast != "2026-10-01 00:00:00" {
+		t.Errorf("groupVersions() => %#v", ubuntu)
+	}
+}
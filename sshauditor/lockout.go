@@ -0,0 +1,81 @@
+package sshauditor
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+//minLockoutFailures is how many connections in a row have to fail after the
+//host answered earlier ones before it is considered to be blocking the scan
+const minLockoutFailures = 3
+
+//lockoutDetector watches the connections made to a host for the pattern of
+//fail2ban or sshd's MaxStartups kicking in: connections the host answered
+//followed by connections that are refused or reset.  Timeouts aren't
+//counted, they are as likely to be the network.
+type lockoutDetector struct {
+	//answered is how many connections the host answered
+	answered int
+	//failures is how many connections failed since the last answered one
+	failures int
+}
+
+//isBlockingError returns true if err is how a host blocking the scanner
+//makes a connection fail
+func isBlockingError(err error) bool {
+	switch connectionErrorType(errors.Cause(err)) {
+	case ConnErrRefused, ConnErrReset:
+		return true
+	}
+	return false
+}
+
+//record adds the outcome of n connections, err is nil if they were answered.
+//Failures only count once the host answered a connection.
+func (d *lockoutDetector) record(n int, err error) {
+	if err == nil {
+		d.answered += n
+		d.failures = 0
+		return
+	}
+	if d.answered > 0 && isBlockingError(err) {
+		d.failures += n
+	}
+}
+
+//blocked returns true if any connection failed since the last one the host
+//answered
+func (d *lockoutDetector) blocked() bool {
+	return d.failures > 0
+}
+
+//threshold returns how many connections the host answered before it
+//started blocking them, or 0 if it didn't
+func (d *lockoutDetector) threshold() int {
+	if d.answered == 0 || d.failures < minLockoutFailures {
+		return 0
+	}
+	return d.answered
+}
+
+//pacedConnections returns how many connections a scan may make to a host
+//that started blocking them after rateLimit connections, staying under it
+func pacedConnections(rateLimit int) int {
+	if rateLimit <= 1 {
+		return 1
+	}
+	return rateLimit - 1
+}
+
+//raisedRateLimit returns the limit of a host that didn't block any of the
+//maxConnections connections a paced run made, so a limit learned from a
+//network problem doesn't stay forever.  If the host blocks again the lower
+//limit is learned anew.
+func raisedRateLimit(maxConnections int) int {
+	return 2 * (maxConnections + 1)
+}
+
+func rateLimitDetail(rateLimit int) string {
+	return fmt.Sprintf("blocked connections after %d", rateLimit)
+}
@@ -0,0 +1,150 @@
+package sshauditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLockoutDetector(t *testing.T) {
+	reset := errors.New("read: connection reset by peer")
+	refused := errors.New("connect: connection refused")
+	timeout := errors.New("dial tcp 10.0.0.1:22: i/o timeout")
+	other := errors.New("ssh: no key found")
+	tests := []struct {
+		name     string
+		outcomes []error
+		expected int
+	}{
+		{"answered", []error{nil, nil, nil}, 0},
+		{"down from the start", []error{reset, reset, reset, nil, reset}, 0},
+		{"blocked", []error{nil, nil, nil, reset, refused, reset}, 3},
+		{"blip", []error{nil, reset, reset, nil, reset, nil}, 0},
+		{"two failures", []error{nil, nil, reset, refused}, 0},
+		{"timeouts", []error{nil, timeout, timeout, timeout}, 0},
+		{"not a network error", []error{nil, other, other, other}, 0},
+	}
+	for _, tt := range tests {
+		var d lockoutDetector
+		for _, err := range tt.outcomes {
+			d.record(1, err)
+		}
+		if got := d.threshold(); got != tt.expected {
+			t.Errorf("%s: threshold() => %d, want %d", tt.name, got, tt.expected)
+		}
+	}
+}
+
+//listenSSHBlocking is listenSSHConfig for a server that drops every
+//connection after the first allowed ones, like fail2ban
+func listenSSHBlocking(t *testing.T, config *ssh.ServerConfig, allowed int) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if n >= allowed {
+				conn.Close()
+				continue
+			}
+			go func() {
+				ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestBruteHostRateLimit(t *testing.T) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(newTestSigners(t)[2])
+	sr := ScanRequest{probe: true}
+	for _, p := range []string{"a", "b", "c", "d", "e", "f"} {
+		sr.credentials = append(sr.credentials, Credential{User: "root", Password: p})
+	}
+	policy := retryPolicy{maxErrors: 3, backoff: time.Millisecond}
+	var raised bool
+	run := func(sr ScanRequest) (rateLimit int, tried, deferred []string) {
+		results := make(chan BruteForceResult, 20)
+		bruteHost(context.Background(), sr, results, time.Second, policy)
+		close(results)
+		raised = false
+		for br := range results {
+			switch {
+			case br.rateLimit != 0:
+				rateLimit = br.rateLimit
+				raised = br.rateLimitRaised
+			case br.deferred != "":
+				deferred = append(deferred, br.cred.Password+" "+br.deferred)
+			case !br.probed:
+				tried = append(tried, br.cred.Password)
+			}
+		}
+		return rateLimit, tried, deferred
+	}
+
+	//The probe's two connections and a are answered, b, c and d are dropped
+	hostport, stop := listenSSHBlocking(t, config, 3)
+	defer stop()
+	sr.hostport = hostport
+	rateLimit, tried, deferred := run(sr)
+	if rateLimit != 3 || raised || len(tried) != 4 || len(deferred) != 2 {
+		t.Errorf("bruteHost() => rate limit %d, tried %v, deferred %v", rateLimit, tried, deferred)
+	}
+
+	//Paced under the limit, the probe doesn't fit and the rest wait.  None
+	//of the connections were blocked, so the limit is raised.
+	hostport, stop = listenSSHBlocking(t, config, 3)
+	defer stop()
+	sr.hostport = hostport
+	sr.maxConnections = pacedConnections(rateLimit)
+	rateLimit, tried, deferred = run(sr)
+	if rateLimit != 6 || !raised || len(tried) != 2 || len(deferred) != 4 || deferred[0] != "c "+DeferredRateLimit {
+		t.Errorf("bruteHost() paced => rate limit %d, raised %v, tried %v, deferred %v", rateLimit, raised, tried, deferred)
+	}
+}
+
+func TestScanQueueRateLimit(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	check(s.Init())
+	_, err = s.AddCredential(Credential{User: "root", Password: "root", ScanInterval: 14})
+	check(err)
+	check(s.addOrUpdateHost(SSHHost{hostport: "192.168.1.1:22", keyfp: "a"}))
+	_, err = s.initHostCreds()
+	check(err)
+
+	check(s.setHostRateLimit("192.168.1.1:22", 4))
+	queue, err := s.getScanQueue()
+	check(err)
+	if len(queue) != 1 || queue[0].maxConnections != 3 {
+		t.Errorf("getScanQueue() => %#v, want at most 3 connections", queue)
+	}
+
+	check(s.ClearHostRateLimit("192.168.1.1:22"))
+	queue, err = s.getScanQueue()
+	check(err)
+	if len(queue) != 1 || queue[0].maxConnections != 0 {
+		t.Errorf("getScanQueue() after ClearHostRateLimit => %#v, want no limit", queue)
+	}
+}
@@ -36,7 +36,7 @@ func (s *SplunkLogSearcher) GetIPs() ([]string, error) {
 
 	//fmt.Println("Session key: ", key.Value)
 
-	rows, _, err := s.conn.Search(`search daysago=2 logcheck user NOT krbtgt | rex "logcheck-(?<logcheck>[0-9.]+)" | table logcheck | dedup logcheck`)
+	rows, _, err := s.conn.Search(`search daysago=2 logcheck user NOT krbtgt | rex "logcheck-(?<logcheck>[0-9a-fA-F.:]+)" | table logcheck | dedup logcheck`)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,155 @@
+package sshauditor
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//ResultMFABlocked is the result of a credential the server accepted as a
+//first factor before demanding a second one
+const ResultMFABlocked = "password-valid-mfa-blocked"
+
+//secondFactorPrompts are lower cased fragments of keyboard-interactive
+//prompts that ask for a second factor rather than a password
+var secondFactorPrompts = []string{
+	"verification code", "one-time", "one time", "otp", "passcode", "token",
+	"authenticator", "2fa", "two-factor", "two factor", "duo", "yubikey",
+}
+
+func isSecondFactorPrompt(prompt string) bool {
+	prompt = strings.ToLower(prompt)
+	for _, p := range secondFactorPrompts {
+		if strings.Contains(prompt, p) {
+			return true
+		}
+	}
+	return false
+}
+
+//authRecorder answers authentication requests with a single secret, a
+//password or private key, and records what the server asked for
+type authRecorder struct {
+	secret string
+	//sent are the methods the secret was sent through
+	sent map[string]bool
+	//codeAfterPassword is set when a keyboard-interactive exchange asked
+	//for a second factor in a later round than the one given the secret
+	codeAfterPassword bool
+	//prompts are the keyboard-interactive instructions and questions
+	prompts []string
+}
+
+func newAuthRecorder(secret string) *authRecorder {
+	return &authRecorder{secret: secret, sent: make(map[string]bool)}
+}
+
+func (r *authRecorder) addPrompt(p string) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return
+	}
+	for _, seen := range r.prompts {
+		if seen == p {
+			return
+		}
+	}
+	r.prompts = append(r.prompts, p)
+}
+
+//challenge answers every question with the secret, except for second factor
+//questions which get an empty answer.  A wrong code costs the user less
+//than a wrong password would.
+func (r *authRecorder) challenge(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	r.addPrompt(instruction)
+	answers := make([]string, len(questions))
+	answered := false
+	for i, q := range questions {
+		r.addPrompt(q)
+		if isSecondFactorPrompt(q) {
+			if r.sent[AuthKeyboardInteractive] {
+				r.codeAfterPassword = true
+			}
+			continue
+		}
+		answers[i] = r.secret
+		answered = true
+	}
+	if answered {
+		r.sent[AuthKeyboardInteractive] = true
+	}
+	return answers, nil
+}
+
+//recordingSigner is a Signer that records when the server accepted its key
+//and asked for a signature
+type recordingSigner struct {
+	ssh.Signer
+	r *authRecorder
+}
+
+func (s recordingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.r.sent[AuthPublicKey] = true
+	return s.Signer.Sign(rand, data)
+}
+
+//authMethods returns the methods the secret is tried with
+func (r *authRecorder) authMethods() ([]ssh.AuthMethod, error) {
+	if isPrivateKey(r.secret) {
+		signer, err := ssh.ParsePrivateKey([]byte(r.secret))
+		if err != nil {
+			return []ssh.AuthMethod{}, err
+		}
+		return []ssh.AuthMethod{
+			ssh.PublicKeys(recordingSigner{signer, r}),
+		}, nil
+	}
+	return []ssh.AuthMethod{
+		ssh.PasswordCallback(func() (string, error) {
+			r.sent[AuthPassword] = true
+			return r.secret, nil
+		}),
+		ssh.KeyboardInteractive(r.challenge),
+	}, nil
+}
+
+//attemptedMethods returns the methods listed in the error the client returns
+//once no authentication methods remain
+func attemptedMethods(err error) []string {
+	msg := err.Error()
+	i := strings.Index(msg, "attempted methods [")
+	if i == -1 {
+		return nil
+	}
+	msg = msg[i+len("attempted methods ["):]
+	if j := strings.IndexByte(msg, ']'); j != -1 {
+		msg = msg[:j]
+	}
+	return strings.Fields(msg)
+}
+
+//partialSuccess returns true if the authentication failure err followed the
+//server accepting the secret as a first factor.  The client only counts a
+//method as attempted once it failed outright, so a method the secret was
+//sent through that is missing from the attempted list partially succeeded.
+func (r *authRecorder) partialSuccess(err error) bool {
+	if !isAuthFailure(err) {
+		return false
+	}
+	attempted := attemptedMethods(err)
+	for m := range r.sent {
+		if !containsAny(attempted, []string{m}) {
+			return true
+		}
+	}
+	return false
+}
+
+//mfaBlocked returns true if the secret is valid but the server wanted a
+//second factor.  A code prompt following the password is only trusted if
+//trustCodePrompt is set, as servers using PAM may ask for the code whether
+//or not the password was right.
+func (r *authRecorder) mfaBlocked(err error, trustCodePrompt bool) bool {
+	return r.partialSuccess(err) || (trustCodePrompt && r.codeAfterPassword)
+}
@@ -0,0 +1,139 @@
+package sshauditor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthRecorderPartialSuccess(t *testing.T) {
+	failed := func(methods string) error {
+		return fmt.Errorf("ssh: handshake failed: ssh: unable to authenticate, attempted methods [%s], no supported methods remain", methods)
+	}
+	tests := []struct {
+		sent     string
+		err      error
+		expected bool
+	}{
+		{AuthPassword, failed("none password"), false},
+		//password,publickey with only the password known
+		{AuthPassword, failed("none"), true},
+		//password,keyboard-interactive failing at the code
+		{AuthPassword, failed("none keyboard-interactive"), true},
+		{AuthKeyboardInteractive, failed("none password keyboard-interactive"), false},
+		{AuthPassword, errors.New("ssh: handshake failed: EOF"), false},
+	}
+	for _, tt := range tests {
+		r := newAuthRecorder("secret")
+		r.sent[tt.sent] = true
+		if got := r.partialSuccess(tt.err); got != tt.expected {
+			t.Errorf("partialSuccess(%q) after sending %s => %v, want %v", tt.err, tt.sent, got, tt.expected)
+		}
+	}
+}
+
+func TestAuthRecorderChallenge(t *testing.T) {
+	r := newAuthRecorder("secret")
+	answers, _ := r.challenge("root", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if !reflect.DeepEqual(answers, []string{"secret", ""}) {
+		t.Errorf("challenge() => %q", answers)
+	}
+	//A code asked for alongside the password says nothing about it
+	if r.codeAfterPassword {
+		t.Errorf("codeAfterPassword set by a single round")
+	}
+	r.challenge("root", "", []string{"Verification code: "}, []bool{false})
+	if !r.codeAfterPassword {
+		t.Errorf("codeAfterPassword not set by a later round")
+	}
+	if !reflect.DeepEqual(r.prompts, []string{"Password:", "Verification code:"}) {
+		t.Errorf("prompts = %q", r.prompts)
+	}
+}
+
+func TestSSHAuthAttemptMFA(t *testing.T) {
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil || answers[0] != "secret" {
+				return nil, fmt.Errorf("denied")
+			}
+			answers, err = client("", "", []string{"Verification code: "}, []bool{true})
+			if err != nil || answers[0] != "123456" {
+				return nil, fmt.Errorf("denied")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(newTestSigners(t)[2])
+	hostport, stop := listenSSHConfig(t, config)
+	defer stop()
+
+	tests := []struct {
+		password        string
+		trustCodePrompt bool
+		expected        string
+	}{
+		{"secret", true, ResultMFABlocked},
+		{"secret", false, ""},
+		{"wrong", true, ""},
+	}
+	for _, tt := range tests {
+		result, prompts, err := sshAuthAttempt(hostport, "root", tt.password, 4*time.Second, authOptions{trustCodePrompt: tt.trustCodePrompt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != tt.expected {
+			t.Errorf("sshAuthAttempt(%q, %v) => %q, want %q", tt.password, tt.trustCodePrompt, result, tt.expected)
+		}
+		if len(prompts) == 0 || prompts[0] != "Password:" {
+			t.Errorf("sshAuthAttempt(%q, %v) prompts => %q", tt.password, tt.trustCodePrompt, prompts)
+		}
+	}
+
+	//A random password never reaches the code prompt, so it can be trusted
+	anything, codePrompt, _, err := acceptsAnything(hostport, 4*time.Second, nil)
+	if err != nil || anything != "" || codePrompt {
+		t.Errorf("acceptsAnything() => %q, %v, %v", anything, codePrompt, err)
+	}
+}
+
+func TestBruteResultPrompts(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cred := Credential{User: "root", Password: "secret", ScanInterval: 14}
+	if _, err := s.AddCredential(cred); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addOrUpdateHost(SSHHost{hostport: "192.168.1.1:22", keyfp: "fp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.initHostCreds(); err != nil {
+		t.Fatal(err)
+	}
+	err = s.updateBruteResult(1, BruteForceResult{
+		hostport: "192.168.1.1:22",
+		cred:     cred,
+		result:   ResultMFABlocked,
+		prompts:  []string{"Password:", "Verification code:"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vulns, err := s.GetVulnerabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 1 || vulns[0].Result != ResultMFABlocked || vulns[0].Prompts != "Password:\nVerification code:" {
+		t.Errorf("GetVulnerabilities() => %#v", vulns)
+	}
+}
@@ -0,0 +1,235 @@
+package sshauditor
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+//migration is a single step in the evolution of the database schema.
+//Migrations are applied in order and never modified once released, a schema
+//change always means appending a new migration to the dialect's list.
+type migration struct {
+	description string
+	apply       func(tx *sqlx.Tx) error
+}
+
+//execMigration returns a migration apply function that runs query
+func execMigration(query string) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+//Migrations that are valid SQL for every dialect are defined once here
+
+var vulnLifecycleMigration = migration{"vulnerability lifecycle", execMigration(`
+	ALTER TABLE host_creds ADD COLUMN state character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN first_detected character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN last_confirmed character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN remediated_at character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN confirmed_result character varying DEFAULT '';
+	UPDATE host_creds SET state='open', first_detected=last_tested, last_confirmed=last_tested,
+		confirmed_result=result WHERE result != '';
+	CREATE INDEX host_creds_state ON host_creds (state) WHERE state != '';
+`)}
+
+var credentialEncryptionMigration = migration{"credential encryption", execMigration(`
+	CREATE TABLE encryption (
+		salt character varying,
+		verifier character varying
+	);
+`)}
+
+var hostNamesMigration = migration{"host names", execMigration(`
+	ALTER TABLE hosts ADD COLUMN names character varying DEFAULT '';
+	ALTER TABLE hosts ADD COLUMN ptr_names character varying DEFAULT '';
+`)}
+
+var hostKeysMigration = migration{"host keys", execMigration(`
+	CREATE TABLE host_keys (
+		hostport character varying,
+		type character varying,
+		fingerprint character varying,
+		bits integer,
+		seen_first character varying,
+		seen_last character varying,
+
+		PRIMARY KEY (hostport, type)
+	);
+	CREATE INDEX host_keys_fingerprint ON host_keys (fingerprint);
+`)}
+
+var hostAlgorithmsMigration = migration{"host algorithms", execMigration(`
+	ALTER TABLE hosts ADD COLUMN kex_algorithms character varying DEFAULT '';
+	ALTER TABLE hosts ADD COLUMN host_key_algorithms character varying DEFAULT '';
+	ALTER TABLE hosts ADD COLUMN ciphers character varying DEFAULT '';
+	ALTER TABLE hosts ADD COLUMN macs character varying DEFAULT '';
+`)}
+
+var hostFindingsMigration = migration{"host findings", execMigration(`
+	CREATE TABLE host_findings (
+		hostport character varying,
+		type character varying,
+		detail character varying,
+		first_found character varying,
+		last_found character varying,
+
+		PRIMARY KEY (hostport, type)
+	);
+`)}
+
+var hostAuthMethodsMigration = migration{"host auth methods", execMigration(`
+	ALTER TABLE hosts ADD COLUMN auth_methods character varying DEFAULT '';
+`)}
+
+var authPromptsMigration = migration{"auth prompts", execMigration(`
+	ALTER TABLE host_creds ADD COLUMN prompts character varying DEFAULT '';
+`)}
+
+var hostKeyMismatchMigration = migration{"host key mismatch", execMigration(`
+	ALTER TABLE hosts ADD COLUMN key_mismatch character varying DEFAULT '';
+`)}
+
+var credentialRulesMigration = migration{"credential rules", execMigration(`
+	ALTER TABLE credentials ADD COLUMN rules character varying DEFAULT '';
+`)}
+
+var scanCheckpointsMigration = migration{"scan checkpoints", execMigration(`
+	ALTER TABLE scan_runs ADD COLUMN interrupted character varying DEFAULT '';
+	CREATE TABLE scan_checkpoints (
+		scan_run_id integer,
+		hostport character varying,
+		"user" character varying,
+		password character varying,
+
+		PRIMARY KEY (scan_run_id, hostport, "user", password)
+	);
+`)}
+
+var deferredCountMigration = migration{"deferred count", execMigration(`
+	ALTER TABLE scan_runs ADD COLUMN deferred_count integer DEFAULT 0;
+`)}
+
+var hostRateLimitMigration = migration{"host rate limit", execMigration(`
+	ALTER TABLE hosts ADD COLUMN rate_limit integer DEFAULT 0;
+`)}
+
+var credentialErrorsMigration = migration{"credential errors", execMigration(`
+	ALTER TABLE host_creds ADD COLUMN error_type character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN last_error character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN error_count integer DEFAULT 0;
+`)}
+
+var credentialErrorTimeMigration = migration{"credential error time", execMigration(`
+	ALTER TABLE host_creds ADD COLUMN last_error_at character varying DEFAULT '';
+`)}
+
+var scanRunFailedMigration = migration{"scan run failed", execMigration(`
+	ALTER TABLE scan_runs ADD COLUMN failed character varying DEFAULT '';
+`)}
+
+var hostProbedMigration = migration{"host probed", execMigration(`
+	ALTER TABLE hosts ADD COLUMN probed character varying DEFAULT '';
+	ALTER TABLE hosts ADD COLUMN probe_code_prompt integer DEFAULT 0;
+`)}
+
+var credentialDeferralMigration = migration{"credential deferrals", execMigration(`
+	ALTER TABLE host_creds ADD COLUMN deferred character varying DEFAULT '';
+	ALTER TABLE host_creds ADD COLUMN deferred_at character varying DEFAULT '';
+`)}
+
+//ErrSchemaTooNew is returned by Init when the database was migrated by a
+//newer version of ssh-auditor than the one running
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of ssh-auditor supports")
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version integer,
+	description character varying,
+	applied character varying,
+
+	PRIMARY KEY (version)
+);
+`
+
+func (s *sqlStore) targetSchemaVersion() int {
+	return len(s.dialect.migrations)
+}
+
+func (s *sqlStore) currentSchemaVersion() (int, error) {
+	var version int
+	err := s.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, errors.Wrap(err, "currentSchemaVersion")
+}
+
+//SchemaUnversioned is the current schema version of a database that was
+//never migrated, either new or from before migrations were tracked
+const SchemaUnversioned = -1
+
+//SchemaVersion returns the schema version the database is at and the version
+//this build of ssh-auditor would migrate it to.  It doesn't change the
+//database, one without a schema_version table is SchemaUnversioned.
+func (s *sqlStore) SchemaVersion() (int, int, error) {
+	var tables int
+	err := s.Get(&tables, s.dialect.tableCount, "schema_version")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "SchemaVersion")
+	}
+	if tables == 0 {
+		return SchemaUnversioned, s.targetSchemaVersion(), nil
+	}
+	current, err := s.currentSchemaVersion()
+	return current, s.targetSchemaVersion(), err
+}
+
+//migrate brings the schema up to date.  All pending migrations are applied
+//in a single transaction, so a failure leaves the database untouched.
+func (s *sqlStore) migrate() error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "migrate")
+	}
+	if s.dialect.lockSchema != "" {
+		_, err = tx.Exec(s.dialect.lockSchema)
+		if err != nil {
+			s.rollback()
+			return errors.Wrap(err, "migrate: locking schema")
+		}
+	}
+	_, err = tx.Exec(schemaVersionTable)
+	if err != nil {
+		s.rollback()
+		return errors.Wrap(err, "migrate")
+	}
+	current, err := s.currentSchemaVersion()
+	if err != nil {
+		s.rollback()
+		return errors.Wrap(err, "migrate")
+	}
+	target := s.targetSchemaVersion()
+	if current > target {
+		s.rollback()
+		return errors.Wrapf(ErrSchemaTooNew, "database is at version %d, this ssh-auditor supports up to %d", current, target)
+	}
+	for version := current + 1; version <= target; version++ {
+		m := s.dialect.migrations[version-1]
+		log.Info("migrating database schema", "version", version, "description", m.description)
+		err = m.apply(tx)
+		if err != nil {
+			s.rollback()
+			return errors.Wrapf(err, "migration %d (%s) failed", version, m.description)
+		}
+		_, err = tx.Exec(fmt.Sprintf(
+			"INSERT INTO schema_version (version, description, applied) VALUES ($1, $2, %s)", s.dialect.now),
+			version, m.description)
+		if err != nil {
+			s.rollback()
+			return errors.Wrapf(err, "recording migration %d", version)
+		}
+	}
+	return errors.Wrap(s.Commit(), "migrate")
+}
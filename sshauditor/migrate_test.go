@@ -0,0 +1,134 @@
+package sshauditor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+//newTempSQLiteStore returns an uninitialized SQLiteStore backed by a file in
+//a temporary directory, along with its path and a function to clean it up
+func newTempSQLiteStore(t *testing.T) (*SQLiteStore, string, func()) {
+	dir, err := ioutil.TempDir("", "ssh-auditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ssh_db.sqlite")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, path, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMigrateFresh(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+
+	current, target, err := s.SchemaVersion()
+	check(err)
+	if current != SchemaUnversioned {
+		t.Errorf("Expected an unversioned schema before Init, got %d", current)
+	}
+	//Asking for the version doesn't create the table
+	var tables int
+	check(s.Get(&tables, s.dialect.tableCount, "schema_version"))
+	if tables != 0 {
+		t.Errorf("Expected no schema_version table before Init")
+	}
+	if target != len(sqliteDialect.migrations) {
+		t.Errorf("Expected target %d, got %d", len(sqliteDialect.migrations), target)
+	}
+
+	check(s.Init())
+	check(s.Init())
+	current, target, err = s.SchemaVersion()
+	check(err)
+	if current != target {
+		t.Errorf("Expected version %d after Init, got %d", target, current)
+	}
+	var applied int
+	check(s.Get(&applied, "SELECT count(*) FROM schema_version"))
+	if applied != target {
+		t.Errorf("Expected %d recorded migrations, got %d", target, applied)
+	}
+}
+
+func TestMigrateLegacyPriority(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+
+	_, err := s.conn.Exec(`
+		CREATE TABLE credentials (
+			user character varying,
+			password character varying,
+			priority DEFAULT 14,
+			PRIMARY KEY (user, password)
+		);
+		INSERT INTO credentials (user, password, priority) VALUES ('root', 'root', 3);`)
+	check(err)
+	check(s.Init())
+
+	creds, err := s.GetAllCreds()
+	check(err)
+	if len(creds) != 1 || creds[0].ScanInterval != 3 {
+		t.Errorf("Expected legacy credential with a scan interval of 3, got %v", creds)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+
+	check(s.Init())
+	_, target, err := s.SchemaVersion()
+	check(err)
+	_, err = s.Exec("INSERT INTO schema_version (version, description, applied) VALUES ($1, 'from the future', '')", target+1)
+	check(err)
+
+	err = s.Init()
+	if errors.Cause(err) != ErrSchemaTooNew {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+	if s.tx != nil {
+		t.Errorf("Expected failed migration to roll back its transaction")
+	}
+}
+
+func TestMigrationsMatch(t *testing.T) {
+	if len(sqliteDialect.migrations) != len(postgresDialect.migrations) {
+		t.Errorf("SQLite has %d migrations but PostgreSQL has %d",
+			len(sqliteDialect.migrations), len(postgresDialect.migrations))
+	}
+	for i := range sqliteDialect.migrations {
+		if i >= len(postgresDialect.migrations) {
+			break
+		}
+		if sqliteDialect.migrations[i].description != postgresDialect.migrations[i].description {
+			t.Errorf("migration %d differs: %q vs %q", i+1,
+				sqliteDialect.migrations[i].description, postgresDialect.migrations[i].description)
+		}
+	}
+}
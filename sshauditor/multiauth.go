@@ -0,0 +1,83 @@
+package sshauditor
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+//errNoPasswordAuth is returned by sshPasswordAttempts when the server didn't
+//let it send a single password
+var errNoPasswordAuth = errors.New("password authentication not offered")
+
+//errPasswordsExhausted stops the client from asking for more passwords than
+//it was given, which it only does if the server offers password
+//authentication again after a partial success
+var errPasswordsExhausted = errors.New("no passwords left to try")
+
+//sshPasswordAttempts tries passwords for user over a single connection to
+//hostport, the way sshd allows MaxAuthTries attempts before disconnecting.
+//Only password authentication is used, keyboard-interactive needs a
+//connection per password so its prompts can be told apart.
+//
+//It returns the results of the passwords the server answered, in order.
+//Those are all of them unless one of them logged in, which is the last
+//result, or the server cut the connection.  A password whose answer was the
+//connection closing is left out so it can be tried again.  If the connection
+//failed before anything was answered or the server doesn't accept passwords
+//no results are returned along with the error.
+func sshPasswordAttempts(hostport, user string, passwords []string, timeout time.Duration, opts authOptions) ([]string, error) {
+	sent := 0
+	password := ssh.PasswordCallback(func() (string, error) {
+		if sent == len(passwords) {
+			return "", errPasswordsExhausted
+		}
+		sent++
+		return passwords[sent-1], nil
+	})
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.RetryableAuthMethod(password, len(passwords))},
+		HostKeyCallback: opts.hostKeyCheck(),
+		Timeout:         timeout,
+		ClientVersion:   "SSH-2.0-Go-ssh-auditor",
+	}
+	//Each password may take the server as long as a whole connection would
+	client, err := dialWithDeadline("tcp", hostport, config, time.Duration(len(passwords)+1)*timeout)
+	if err == nil {
+		defer client.Close()
+		results := make([]string, sent)
+		results[sent-1] = classifyLogin(client, hostport)
+		return results, nil
+	}
+	if isAuthFailure(err) {
+		if sent == 0 {
+			return nil, errNoPasswordAuth
+		}
+		results := make([]string, sent)
+		//The client only counts a method as attempted once it failed
+		//outright, so the last password partially succeeded
+		if !containsAny(attemptedMethods(err), []string{AuthPassword}) {
+			results[sent-1] = ResultMFABlocked
+		}
+		return results, nil
+	}
+	if sent <= 1 {
+		return nil, classifyConnectionError(err)
+	}
+	return make([]string, sent-1), classifyConnectionError(err)
+}
+
+//passwordBatch returns the credentials at the start of creds that can be
+//tried over one connection, at most n passwords for the same user
+func passwordBatch(creds []Credential, n int) []Credential {
+	i := 0
+	for i < len(creds) && i < n && creds[i].User == creds[0].User && !isPrivateKey(creds[i].Password) {
+		i++
+	}
+	if i == 0 {
+		return creds[:1]
+	}
+	return creds[:i]
+}
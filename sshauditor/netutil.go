@@ -1,10 +1,22 @@
 package sshauditor
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+//maxIPv6Hosts is the largest IPv6 range that will be enumerated without
+//ScanConfiguration.AllowLargeIPv6, a /112.  Sweeping anything approaching a
+///64 one address at a time will never finish.
+const maxIPv6Hosts = 1 << 16
+
 func inc(ip net.IP) {
 	for j := len(ip) - 1; j >= 0; j-- {
 		ip[j]++
@@ -14,45 +26,505 @@ func inc(ip net.IP) {
 	}
 }
 
-func ExpandCIDRs(netblocks []string) ([]string, error) {
-	var hosts []string
-	for _, netblock := range netblocks {
-		//If there's no slash, just treat as a single host
-		if !strings.ContainsRune(netblock, '/') {
-			hosts = append(hosts, netblock)
-			continue
+//addrRange is an inclusive range of addresses, always stored in 16 byte form
+type addrRange struct {
+	first net.IP
+	last  net.IP
+}
+
+func (r addrRange) contains(ip net.IP) bool {
+	return bytes.Compare(ip, r.first) >= 0 && bytes.Compare(ip, r.last) <= 0
+}
+
+func (r addrRange) size() *big.Int {
+	n := new(big.Int).SetBytes(r.last)
+	n.Sub(n, new(big.Int).SetBytes(r.first))
+	return n.Add(n, big.NewInt(1))
+}
+
+func cidrRange(ipnet *net.IPNet) addrRange {
+	first := ipnet.IP.Mask(ipnet.Mask)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^ipnet.Mask[i]
+	}
+	return addrRange{first: first.To16(), last: last.To16()}
+}
+
+//maxTargetRanges limits how many separate ranges a single octet pattern
+//may expand to, so something like *.*.*.1 is refused instead of exhausting
+//memory
+const maxTargetRanges = 1 << 16
+
+//target is a single entry from an include or exclude list, either a sorted
+//list of address ranges or a name that is passed through as is
+type target struct {
+	spec  string
+	addrs []addrRange
+	name  string
+}
+
+//parseTarget parses a single target, which is one of
+//
+//	a single address       192.168.1.1, 2001:db8::1
+//	a CIDR block           192.168.1.0/24, 2001:db8::/120
+//	an address range       192.168.1.10-192.168.1.20, 2001:db8::1-2001:db8::ff
+//	an nmap octet pattern  10.0.0.1-50, 10.0.0.*, 10.0.1-3.0/24
+//
+//Anything else without a slash is treated as a hostname.
+func parseTarget(s string) (target, error) {
+	base, prefix := s, ""
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		base, prefix = s[:i], s[i+1:]
+	}
+	if net.ParseIP(base) != nil {
+		if prefix == "" {
+			ip := net.ParseIP(base).To16()
+			return target{spec: s, addrs: []addrRange{{first: ip, last: ip}}}, nil
 		}
-		ip, ipnet, err := net.ParseCIDR(netblock)
+		_, ipnet, err := net.ParseCIDR(s)
 		if err != nil {
-			return hosts, err
+			return target{}, err
 		}
-		for h := ip.Mask(ipnet.Mask); ipnet.Contains(h); inc(h) {
-			hosts = append(hosts, h.String())
+		return target{spec: s, addrs: []addrRange{cidrRange(ipnet)}}, nil
+	}
+	if r, ok, err := parseAddrRange(base); ok {
+		if err == nil && prefix != "" {
+			err = fmt.Errorf("invalid target %s: address ranges can't have a prefix length", s)
 		}
+		return target{spec: s, addrs: []addrRange{r}}, err
 	}
-	return hosts, nil
+	if isOctetPattern(base) {
+		ranges, err := parseOctetPattern(base, prefix)
+		if err != nil {
+			return target{}, fmt.Errorf("invalid target %s: %s", s, err)
+		}
+		return target{spec: s, addrs: ranges}, nil
+	}
+	if prefix != "" {
+		_, _, err := net.ParseCIDR(s)
+		return target{}, err
+	}
+	return target{spec: s, name: s}, nil
 }
 
-func EnumerateHosts(netblocks []string, exclude []string) ([]string, error) {
-	var hosts []string
-	allHosts, err := ExpandCIDRs(netblocks)
-	if err != nil {
-		return hosts, err
+//parseAddrRange parses first-last where both ends are full addresses of the
+//same family.  ok is false if s is not of that form at all.
+func parseAddrRange(s string) (r addrRange, ok bool, err error) {
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		return r, false, nil
+	}
+	first, last := net.ParseIP(s[:i]), net.ParseIP(s[i+1:])
+	if first == nil || last == nil {
+		return r, false, nil
+	}
+	if (first.To4() == nil) != (last.To4() == nil) {
+		return r, true, fmt.Errorf("invalid range %s: mixed address families", s)
+	}
+	r = addrRange{first: first.To16(), last: last.To16()}
+	if bytes.Compare(r.first, r.last) > 0 {
+		return r, true, fmt.Errorf("invalid range %s: end is before start", s)
+	}
+	return r, true, nil
+}
+
+//isOctetPattern returns true if s looks like an IPv4 address where some of
+//the octets are ranges or wildcards
+func isOctetPattern(s string) bool {
+	if strings.Count(s, ".") != 3 || !strings.ContainsAny(s, "-*") {
+		return false
+	}
+	return strings.Trim(s, "0123456789.-*") == ""
+}
+
+//parseOctetPattern expands an nmap style IPv4 pattern where each octet is a
+//number, a range such as 1-50 (either end may be left off) or *.  If prefix
+//is given the pattern is widened to the enclosing network of that length,
+//so 10.0.1-3.0/24 is 10.0.1.0/24, 10.0.2.0/24 and 10.0.3.0/24.
+func parseOctetPattern(s string, prefix string) ([]addrRange, error) {
+	var lo, hi [4]int
+	for i, o := range strings.Split(s, ".") {
+		var err error
+		switch {
+		case o == "*":
+			lo[i], hi[i] = 0, 255
+		case strings.Contains(o, "-"):
+			parts := strings.SplitN(o, "-", 2)
+			lo[i], hi[i] = 0, 255
+			if parts[0] != "" {
+				lo[i], err = parseOctet(parts[0])
+			}
+			if err == nil && parts[1] != "" {
+				hi[i], err = parseOctet(parts[1])
+			}
+		default:
+			lo[i], err = parseOctet(o)
+			hi[i] = lo[i]
+		}
+		if err != nil {
+			return nil, err
+		}
+		if lo[i] > hi[i] {
+			return nil, fmt.Errorf("octet range %s is backwards", o)
+		}
+	}
+	if prefix != "" {
+		bits, err := strconv.Atoi(prefix)
+		if err != nil || bits < 0 || bits > 32 {
+			return nil, fmt.Errorf("invalid prefix length %s", prefix)
+		}
+		for i := range lo {
+			keep := bits - 8*i
+			if keep >= 8 {
+				continue
+			}
+			if keep < 0 {
+				keep = 0
+			}
+			mask := 0xff << uint(8-keep) & 0xff
+			lo[i] &= mask
+			hi[i] |= ^mask & 0xff
+		}
+	}
+	//Octets after k are all 0-255, so each combination of the octets before
+	//k is one contiguous range
+	k := 3
+	for k > 0 && lo[k] == 0 && hi[k] == 255 {
+		k--
+	}
+	count := 1
+	for i := 0; i < k; i++ {
+		count *= hi[i] - lo[i] + 1
+	}
+	if count > maxTargetRanges {
+		return nil, fmt.Errorf("pattern expands to %d separate ranges, refusing more than %d", count, maxTargetRanges)
+	}
+	ranges := make([]addrRange, 0, count)
+	cur := lo
+	for {
+		first := net.IPv4(byte(cur[0]), byte(cur[1]), byte(cur[2]), byte(cur[3])).To16()
+		last := make(net.IP, len(first))
+		copy(last, first)
+		last[12+k] = byte(hi[k])
+		for i := k + 1; i < 4; i++ {
+			last[12+i] = 255
+		}
+		ranges = append(ranges, addrRange{first: first, last: last})
+		//Step the octets before k like an odometer
+		i := k - 1
+		for ; i >= 0; i-- {
+			if cur[i] < hi[i] {
+				cur[i]++
+				break
+			}
+			cur[i] = lo[i]
+		}
+		if i < 0 {
+			return ranges, nil
+		}
+	}
+}
+
+func parseOctet(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return 0, fmt.Errorf("invalid octet %s", s)
+	}
+	return n, nil
+}
+
+//ParsePorts parses port specifications such as "22", "22,2222" or
+//"2200-2299" into a list of ports, dropping duplicates.
+func ParsePorts(specs []string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, spec := range specs {
+		for _, p := range strings.Split(spec, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			first, last := p, p
+			if i := strings.IndexByte(p, '-'); i != -1 {
+				first, last = p[:i], p[i+1:]
+			}
+			lo, err := parsePort(first)
+			if err != nil {
+				return nil, err
+			}
+			hi, err := parsePort(last)
+			if err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				return nil, fmt.Errorf("invalid port range %s: end is before start", p)
+			}
+			for port := lo; port <= hi; port++ {
+				if !seen[port] {
+					seen[port] = true
+					ports = append(ports, port)
+				}
+			}
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("invalid port %s", s)
+	}
+	return n, nil
+}
+
+//HostEnumerator lazily enumerates every host in a list of include targets
+//that is not in a list of exclude targets.  Nothing is expanded up front, so
+//memory use does not depend on the size of the ranges involved.
+type HostEnumerator struct {
+	include      []target
+	exclude      []addrRange
+	excludeNames map[string]bool
+}
+
+//NewHostEnumerator parses include and exclude, which are lists of targets in
+//any of the forms understood by parseTarget
+func NewHostEnumerator(include []string, exclude []string) (*HostEnumerator, error) {
+	e := &HostEnumerator{excludeNames: make(map[string]bool)}
+	for _, s := range include {
+		t, err := parseTarget(s)
+		if err != nil {
+			return nil, err
+		}
+		e.include = append(e.include, t)
+	}
+	for _, s := range exclude {
+		t, err := parseTarget(s)
+		if err != nil {
+			return nil, err
+		}
+		if t.name != "" {
+			e.excludeNames[t.name] = true
+			continue
+		}
+		e.exclude = append(e.exclude, t.addrs...)
+	}
+	e.exclude = mergeRanges(e.exclude)
+	return e, nil
+}
+
+//mergeRanges sorts ranges and combines any that overlap or touch
+func mergeRanges(ranges []addrRange) []addrRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].first, ranges[j].first) < 0
+	})
+	merged := []addrRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		cur := &merged[len(merged)-1]
+		adjacent := make(net.IP, len(cur.last))
+		copy(adjacent, cur.last)
+		inc(adjacent)
+		wrapped := adjacent.Equal(net.IPv6zero)
+		if bytes.Compare(r.first, cur.last) <= 0 || (!wrapped && adjacent.Equal(r.first)) {
+			if bytes.Compare(r.last, cur.last) > 0 {
+				cur.last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+//excluding returns the exclude range containing ip, if any
+func (e *HostEnumerator) excluding(ip net.IP) (addrRange, bool) {
+	i := sort.Search(len(e.exclude), func(i int) bool {
+		return bytes.Compare(e.exclude[i].last, ip) >= 0
+	})
+	if i < len(e.exclude) && e.exclude[i].contains(ip) {
+		return e.exclude[i], true
+	}
+	return addrRange{}, false
+}
+
+//checkIPv6Size returns an error if any IPv6 include range is larger than
+//maxIPv6Hosts
+func (e *HostEnumerator) checkIPv6Size() error {
+	limit := big.NewInt(maxIPv6Hosts)
+	for _, t := range e.include {
+		for _, r := range t.addrs {
+			if r.first.To4() != nil {
+				continue
+			}
+			if r.size().Cmp(limit) > 0 {
+				return fmt.Errorf("IPv6 range %s has %s addresses, refusing to enumerate more than %d", t.spec, r.size(), maxIPv6Hosts)
+			}
+		}
+	}
+	return nil
+}
+
+//Count returns the number of hosts the enumerator will yield
+func (e *HostEnumerator) Count() *big.Int {
+	total := new(big.Int)
+	for _, t := range e.include {
+		if t.name != "" {
+			if !e.excludeNames[t.name] {
+				total.Add(total, big.NewInt(1))
+			}
+			continue
+		}
+		for _, r := range t.addrs {
+			total.Add(total, r.size())
+			for _, x := range e.exclude {
+				first, last := x.first, x.last
+				if bytes.Compare(first, r.first) < 0 {
+					first = r.first
+				}
+				if bytes.Compare(last, r.last) > 0 {
+					last = r.last
+				}
+				if bytes.Compare(first, last) <= 0 {
+					total.Sub(total, addrRange{first, last}.size())
+				}
+			}
+		}
+	}
+	return total
+}
+
+//Iterate returns a new HostIterator positioned before the first host
+func (e *HostEnumerator) Iterate() *HostIterator {
+	return &HostIterator{e: e}
+}
+
+//HostIterator yields the hosts of a HostEnumerator one at a time
+type HostIterator struct {
+	e    *HostEnumerator
+	idx  int
+	ri   int
+	next net.IP
+}
+
+//Next returns the next host, or false once every host has been returned
+func (it *HostIterator) Next() (string, bool) {
+	for it.idx < len(it.e.include) {
+		t := it.e.include[it.idx]
+		if t.name != "" {
+			it.idx++
+			if it.e.excludeNames[t.name] {
+				continue
+			}
+			return t.name, true
+		}
+		r := t.addrs[it.ri]
+		if it.next == nil {
+			it.next = make(net.IP, len(r.first))
+			copy(it.next, r.first)
+		}
+		//Jump over excluded ranges instead of checking them one at a time
+		if x, excluded := it.e.excluding(it.next); excluded {
+			if bytes.Compare(x.last, r.last) >= 0 {
+				it.advance()
+				continue
+			}
+			copy(it.next, x.last)
+			inc(it.next)
+			continue
+		}
+		host := it.next.String()
+		if it.next.Equal(r.last) {
+			it.advance()
+		} else {
+			inc(it.next)
+		}
+		return host, true
+	}
+	return "", false
+}
+
+//advance moves the iterator on to the next range, or the next include
+//target after its last range
+func (it *HostIterator) advance() {
+	it.next = nil
+	it.ri++
+	if it.ri >= len(it.e.include[it.idx].addrs) {
+		it.idx++
+		it.ri = 0
 	}
+}
 
-	allExcludeHosts, err := ExpandCIDRs(exclude)
+//ExpandCIDRs returns every host in netblocks.  It builds the full list in
+//memory, use NewHostEnumerator for large ranges.
+func ExpandCIDRs(netblocks []string) ([]string, error) {
+	return EnumerateHosts(netblocks, nil)
+}
+
+//EnumerateHosts returns every host in netblocks that is not in exclude.  It
+//builds the full list in memory, use NewHostEnumerator for large ranges.
+func EnumerateHosts(netblocks []string, exclude []string) ([]string, error) {
+	var hosts []string
+	e, err := NewHostEnumerator(netblocks, exclude)
 	if err != nil {
 		return hosts, err
 	}
-	excludeHosts := make(map[string]bool)
-	for _, ip := range allExcludeHosts {
-		excludeHosts[ip] = true
+	it := e.Iterate()
+	for h, ok := it.Next(); ok; h, ok = it.Next() {
+		hosts = append(hosts, h)
 	}
+	return hosts, nil
+}
 
-	for _, ip := range allHosts {
-		if _, excluded := excludeHosts[ip]; !excluded {
-			hosts = append(hosts, ip)
+//ParseIPv6Seeds extracts IPv6 addresses from neighbour tables or hosts
+//files, such as the output of 'ip -6 neigh' or /etc/hosts, to use as
+//discovery targets where sweeping the prefix is not feasible.  The first
+//field of each line is used and link-local addresses get their zone from a
+//'dev' field.  Comments, IPv4, loopback and multicast addresses and failed
+//neighbour entries are skipped.
+func ParseIPv6Seeds(r io.Reader) ([]string, error) {
+	var addrs []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		state := fields[len(fields)-1]
+		if state == "FAILED" || state == "INCOMPLETE" {
+			continue
+		}
+		addr, zone := fields[0], ""
+		if i := strings.IndexByte(addr, '%'); i != -1 {
+			addr, zone = addr[:i], addr[i+1:]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() != nil || ip.IsLoopback() || ip.IsMulticast() || ip.IsUnspecified() {
+			continue
+		}
+		seed := ip.String()
+		if ip.IsLinkLocalUnicast() {
+			for i := 1; zone == "" && i+1 < len(fields); i++ {
+				if fields[i] == "dev" {
+					zone = fields[i+1]
+				}
+			}
+			if zone != "" {
+				seed += "%" + zone
+			}
+		}
+		if !seen[seed] {
+			seen[seed] = true
+			addrs = append(addrs, seed)
 		}
 	}
-	return hosts, nil
+	return addrs, scanner.Err()
 }
@@ -1,6 +1,11 @@
 package sshauditor
 
-import "testing"
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 var testCases = []struct {
 	include   []string
@@ -13,6 +18,75 @@ var testCases = []struct {
 	{[]string{"192.168.1.0/24"}, []string{"192.168.1.30/30"}, 252, false},
 	{[]string{"192.168.1.0/33"}, []string{}, 0, true},
 	{[]string{"192.168.1.1"}, []string{}, 1, false},
+	{[]string{"192.168.1.0/24"}, []string{"192.168.1.30"}, 255, false},
+	{[]string{"192.168.1.0/24"}, []string{"192.168.1.0/26", "192.168.1.64/26", "192.168.1.60/30"}, 128, false},
+	{[]string{"192.168.1.0/24"}, []string{"192.168.0.0/16"}, 0, false},
+	{[]string{"192.168.1.0/30", "example.com"}, []string{"example.com"}, 4, false},
+	{[]string{"255.255.255.252/30"}, []string{}, 4, false},
+	{[]string{"2001:db8::/120"}, []string{}, 256, false},
+	{[]string{"2001:db8::/120"}, []string{"2001:db8::/126", "2001:db8::ff"}, 251, false},
+	{[]string{"2001:db8::1", "192.168.1.1"}, []string{}, 2, false},
+	{[]string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126"}, []string{}, 4, false},
+	{[]string{"2001:db8::/129"}, []string{}, 0, true},
+	{[]string{"10.0.0.1-50"}, []string{}, 50, false},
+	{[]string{"10.0.0.*"}, []string{"10.0.0.10-19"}, 246, false},
+	{[]string{"10.0.1-3.0/24"}, []string{"10.0.2.*"}, 512, false},
+	{[]string{"10.0-1.5-6.1"}, []string{}, 4, false},
+	{[]string{"10.0.0.-9"}, []string{}, 10, false},
+	{[]string{"192.168.1.250-192.168.2.5"}, []string{"192.168.1.255-192.168.2.0"}, 10, false},
+	{[]string{"2001:db8::1-2001:db8::ff"}, []string{}, 255, false},
+	{[]string{"10.0.0.1-300"}, []string{}, 0, true},
+	{[]string{"10.0.0.50-1"}, []string{}, 0, true},
+	{[]string{"10.0.0.9-10.0.0.1"}, []string{}, 0, true},
+	{[]string{"10.0.0.1-2001:db8::1"}, []string{}, 0, true},
+	{[]string{"10.0.0.1-10.0.0.9/24"}, []string{}, 0, true},
+	{[]string{"*.*.*.1"}, []string{}, 0, true},
+}
+
+func TestParseTargetPattern(t *testing.T) {
+	e, err := NewHostEnumerator([]string{"10.0-1.1.1-2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts []string
+	it := e.Iterate()
+	for h, ok := it.Next(); ok; h, ok = it.Next() {
+		hosts = append(hosts, h)
+	}
+	expected := []string{"10.0.1.1", "10.0.1.2", "10.1.1.1", "10.1.1.2"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected %v, got %v", expected, hosts)
+	}
+	for _, name := range []string{"web-1.example.com", "10.0.0.1-50", "10.0.0.1-10.0.0.5", "fe80::1%eth0"} {
+		if isHostname(name) != (name == "web-1.example.com") {
+			t.Errorf("isHostname(%q) => %v", name, isHostname(name))
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	cases := []struct {
+		specs     []string
+		expected  []int
+		wanterror bool
+	}{
+		{[]string{"22"}, []int{22}, false},
+		{[]string{"22,2222"}, []int{22, 2222}, false},
+		{[]string{"22", "2200-2203", "2201"}, []int{22, 2200, 2201, 2202, 2203}, false},
+		{[]string{"0"}, nil, true},
+		{[]string{"65536"}, nil, true},
+		{[]string{"2299-2200"}, nil, true},
+		{[]string{"ssh"}, nil, true},
+	}
+	for _, tt := range cases {
+		ports, err := ParsePorts(tt.specs)
+		if (err != nil) != tt.wanterror {
+			t.Errorf("ParsePorts(%v) error => %v", tt.specs, err)
+		}
+		if !reflect.DeepEqual(ports, tt.expected) {
+			t.Errorf("ParsePorts(%v) => %v, want %v", tt.specs, ports, tt.expected)
+		}
+	}
 }
 
 func TestEnumerateHosts(t *testing.T) {
@@ -29,3 +103,109 @@ func TestEnumerateHosts(t *testing.T) {
 		}
 	}
 }
+
+func TestHostEnumeratorCount(t *testing.T) {
+	for _, tt := range testCases {
+		if tt.wanterror {
+			continue
+		}
+		e, err := NewHostEnumerator(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := e.Count(); c.Int64() != int64(tt.expected) {
+			t.Errorf("NewHostEnumerator(%#v, %#v).Count() => %v, want %v", tt.include, tt.exclude, c, tt.expected)
+		}
+	}
+}
+
+func TestHostEnumeratorLargeRange(t *testing.T) {
+	e, err := NewHostEnumerator([]string{"10.0.0.0/8"}, []string{"10.0.0.0/12", "10.16.0.2/31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := e.Count(); c.Int64() != 1<<24-1<<20-2 {
+		t.Errorf("Count() => %v, want %v", c, 1<<24-1<<20-2)
+	}
+	var hosts []string
+	it := e.Iterate()
+	for h, ok := it.Next(); ok && len(hosts) < 4; h, ok = it.Next() {
+		hosts = append(hosts, h)
+	}
+	expected := []string{"10.16.0.0", "10.16.0.1", "10.16.0.4", "10.16.0.5"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestExpandScanConfigurationOrder(t *testing.T) {
+	hostChan, err := expandScanConfiguration(context.Background(), ScanConfiguration{
+		Include: []string{"192.168.1.0/31"},
+		Exclude: []string{},
+		Ports:   []int{22, 2222},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hostports []string
+	for hp := range hostChan {
+		hostports = append(hostports, hp)
+	}
+	expected := []string{"192.168.1.0:22", "192.168.1.1:22", "192.168.1.0:2222", "192.168.1.1:2222"}
+	if !reflect.DeepEqual(hostports, expected) {
+		t.Errorf("Expected %v, got %v", expected, hostports)
+	}
+}
+
+func TestExpandScanConfigurationIPv6(t *testing.T) {
+	hostChan, err := expandScanConfiguration(context.Background(), ScanConfiguration{
+		Include: []string{"2001:db8::/127", "fe80::1%eth0"},
+		Ports:   []int{22},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hostports []string
+	for hp := range hostChan {
+		hostports = append(hostports, hp)
+	}
+	expected := []string{"[2001:db8::]:22", "[2001:db8::1]:22", "[fe80::1%eth0]:22"}
+	if !reflect.DeepEqual(hostports, expected) {
+		t.Errorf("Expected %v, got %v", expected, hostports)
+	}
+
+	large := ScanConfiguration{Include: []string{"2001:db8::/64"}, Ports: []int{22}}
+	if _, err := expandScanConfiguration(context.Background(), large); err == nil {
+		t.Errorf("Expected a /64 to be refused")
+	}
+	large.AllowLargeIPv6 = true
+	if _, err := expandScanConfiguration(context.Background(), large); err != nil {
+		t.Errorf("Expected a /64 to be allowed with AllowLargeIPv6: %v", err)
+	}
+	if _, err := expandScanConfiguration(context.Background(), ScanConfiguration{Include: []string{"2001:db8::/112", "10.0.0.0/8"}}); err != nil {
+		t.Errorf("Expected a /112 and a large IPv4 range to be allowed: %v", err)
+	}
+}
+
+func TestParseIPv6Seeds(t *testing.T) {
+	input := `fe80::1 dev eth0 lladdr 00:11:22:33:44:55 router REACHABLE
+2001:db8::10 dev eth0 lladdr 00:11:22:33:44:66 STALE
+2001:db8::11 dev eth0  FAILED
+2001:db8::10 dev eth0 lladdr 00:11:22:33:44:66 DELAY
+# hosts file
+127.0.0.1	localhost
+::1	localhost ip6-localhost
+ff02::1	ip6-allnodes
+2001:db8::20	build.example.com build # comment
+fe80::2%wlan0	printer
+not-an-address
+`
+	seeds, err := ParseIPv6Seeds(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"fe80::1%eth0", "2001:db8::10", "2001:db8::20", "fe80::2%wlan0"}
+	if !reflect.DeepEqual(seeds, expected) {
+		t.Errorf("Expected %v, got %v", expected, seeds)
+	}
+}
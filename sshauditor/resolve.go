@@ -0,0 +1,90 @@
+package sshauditor
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Resolver performs forward and reverse DNS lookups.  *net.Resolver
+//implements it, tests can substitute a stub.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+const dnsTimeout = 4 * time.Second
+
+//isHostname returns true if target is a name to resolve rather than an
+//address, an address with a zone or any of the range forms of parseTarget
+func isHostname(target string) bool {
+	t, err := parseTarget(target)
+	return err == nil && t.name != "" && !strings.Contains(target, "%")
+}
+
+//joinNames returns names without trailing dots, sorted, deduplicated and
+//joined with commas, the form they are stored in
+func joinNames(names []string) string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, n := range names {
+		n = strings.TrimSuffix(n, ".")
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		unique = append(unique, n)
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ",")
+}
+
+//resolveTargets replaces every hostname in targets with all of its A and
+//AAAA records.  The returned map lists the names each address was found
+//under, and failed lists the hostnames that could not be resolved.
+func resolveTargets(r Resolver, targets []string) (resolved []string, names map[string][]string, failed []string) {
+	names = make(map[string][]string)
+	for _, t := range targets {
+		if !isHostname(t) {
+			resolved = append(resolved, t)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.TODO(), dnsTimeout)
+		addrs, err := r.LookupHost(ctx, t)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			failed = append(failed, t)
+			continue
+		}
+		for _, addr := range addrs {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+			a := ip.String()
+			//Several names may share an address, only enumerate it once
+			if _, seen := names[a]; !seen {
+				resolved = append(resolved, a)
+			}
+			names[a] = append(names[a], t)
+		}
+	}
+	return resolved, names, failed
+}
+
+//lookupPTR returns the PTR names for the host in hostport in stored form
+func lookupPTR(r Resolver, hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), dnsTimeout)
+	defer cancel()
+	names, err := r.LookupAddr(ctx, host)
+	if err != nil {
+		return ""
+	}
+	return joinNames(names)
+}
@@ -0,0 +1,169 @@
+package sshauditor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+//stubResolver answers lookups from fixed tables instead of DNS
+type stubResolver struct {
+	hosts map[string][]string
+	ptrs  map[string][]string
+}
+
+func (r stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func (r stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.ptrs[addr]; ok {
+		return names, nil
+	}
+	return nil, fmt.Errorf("no PTR for %s", addr)
+}
+
+var testResolver = stubResolver{
+	hosts: map[string][]string{
+		"bastion.example.com": {"192.0.2.1", "2001:db8::1"},
+		"www.example.com":     {"192.0.2.1"},
+		"local.example.com":   {"127.0.0.1"},
+	},
+	ptrs: map[string][]string{
+		"192.0.2.1": {"web1.example.com.", "bastion.example.com."},
+		"127.0.0.1": {"localhost."},
+	},
+}
+
+func TestResolveTargets(t *testing.T) {
+	targets := []string{"10.0.0.0/24", "bastion.example.com", "www.example.com", "missing.example.com", "fe80::1%eth0"}
+	resolved, names, failed := resolveTargets(testResolver, targets)
+
+	expected := []string{"10.0.0.0/24", "192.0.2.1", "2001:db8::1", "fe80::1%eth0"}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("resolveTargets() = %v, want %v", resolved, expected)
+	}
+	if !reflect.DeepEqual(failed, []string{"missing.example.com"}) {
+		t.Errorf("resolveTargets() failed = %v, want [missing.example.com]", failed)
+	}
+	if got := joinNames(names["192.0.2.1"]); got != "bastion.example.com,www.example.com" {
+		t.Errorf("names for 192.0.2.1 = %q", got)
+	}
+	if got := joinNames(names["2001:db8::1"]); got != "bastion.example.com" {
+		t.Errorf("names for 2001:db8::1 = %q", got)
+	}
+}
+
+func TestLookupPTR(t *testing.T) {
+	cases := []struct {
+		hostport string
+		expected string
+	}{
+		{"192.0.2.1:22", "bastion.example.com,web1.example.com"},
+		{"192.0.2.2:22", ""},
+		{"bogus", ""},
+	}
+	for _, tc := range cases {
+		if got := lookupPTR(testResolver, tc.hostport); got != tc.expected {
+			t.Errorf("lookupPTR(%q) = %q, want %q", tc.hostport, got, tc.expected)
+		}
+	}
+}
+
+//listenBanner starts a listener on localhost that sends an SSH banner to
+//every connection and returns its port
+func listenBanner(t *testing.T) (int, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-Test\r\n"))
+			conn.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, func() { l.Close() }
+}
+
+func TestDiscoverHostname(t *testing.T) {
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	port, stop := listenBanner(t)
+	defer stop()
+
+	auditor := New(s)
+	auditor.SetResolver(testResolver)
+	err := auditor.Discover(context.Background(), ScanConfiguration{
+		Include:     []string{"local.example.com"},
+		Ports:       []int{port},
+		Concurrency: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := s.GetActiveHosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("expected 1 host, got %v", hosts)
+	}
+	h := hosts[0]
+	if h.Hostport != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+		t.Errorf("Hostport = %q", h.Hostport)
+	}
+	if h.Names != "local.example.com" || h.PTRNames != "localhost" {
+		t.Errorf("Names = %q, PTRNames = %q", h.Names, h.PTRNames)
+	}
+
+	//Rediscovering by address keeps the name
+	err = auditor.Discover(context.Background(), ScanConfiguration{
+		Include:     []string{"127.0.0.1"},
+		Ports:       []int{port},
+		Concurrency: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err = s.GetActiveHosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Names != "local.example.com" {
+		t.Errorf("expected name to be kept, got %v", hosts)
+	}
+}
+
+func TestDiscoverUnresolvableExclude(t *testing.T) {
+	s, _, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	auditor := New(s)
+	auditor.SetResolver(testResolver)
+	err := auditor.Discover(context.Background(), ScanConfiguration{
+		Include:     []string{"127.0.0.1"},
+		Exclude:     []string{"missing.example.com"},
+		Ports:       []int{22},
+		Concurrency: 1,
+	})
+	if err == nil {
+		t.Fatal("expected an error for an unresolvable exclusion")
+	}
+}
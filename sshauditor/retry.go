@@ -0,0 +1,87 @@
+package sshauditor
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Defaults for the ScanConfiguration fields controlling how hard a host
+//returning errors is tried
+const (
+	DefaultMaxHostErrors = 5
+	DefaultRetryBackoff  = time.Second
+	//maxRetryBackoff caps the delay between retries
+	maxRetryBackoff = 30 * time.Second
+)
+
+//retryPolicy is the error budget and retry schedule for each host
+type retryPolicy struct {
+	//maxErrors is how many failed connections a host may cause before its
+	//circuit breaker opens and its remaining credentials are deferred
+	maxErrors int
+	//retries is how often an attempt failing with a transient error is
+	//retried, backoff is the delay before the first retry
+	retries int
+	backoff time.Duration
+}
+
+func newRetryPolicy(cfg ScanConfiguration) retryPolicy {
+	p := retryPolicy{
+		maxErrors: cfg.MaxHostErrors,
+		retries:   cfg.Retries,
+		backoff:   cfg.RetryBackoff,
+	}
+	if p.maxErrors <= 0 {
+		p.maxErrors = DefaultMaxHostErrors
+	}
+	if p.backoff <= 0 {
+		p.backoff = DefaultRetryBackoff
+	}
+	return p
+}
+
+//delay returns how long to wait before retry number retry, counting from
+//0.  The delay doubles with each retry up to maxRetryBackoff and a random
+//half of it is jitter, so hosts throttling connections aren't hit by every
+//worker at once.
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff
+	for i := 0; i < retry && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+//wait sleeps before retry number retry, returning false if ctx was canceled
+//in the meantime
+func (p retryPolicy) wait(ctx context.Context, retry int) bool {
+	t := time.NewTimer(p.delay(retry))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//isTransientError returns true if err is a ConnectionError that may not
+//happen again, like a timeout or sshd dropping connections over its
+//MaxStartups limit
+func isTransientError(err error) bool {
+	var ce *ConnectionError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	switch ce.Type {
+	case ConnErrTimeout, ConnErrReset, ConnErrRefused:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package sshauditor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := newRetryPolicy(ScanConfiguration{Retries: 10, RetryBackoff: time.Second})
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{0, 500 * time.Millisecond, time.Second},
+		{1, time.Second, 2 * time.Second},
+		{3, 4 * time.Second, 8 * time.Second},
+		{10, maxRetryBackoff / 2, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(tt.retry); d < tt.min || d > tt.max {
+				t.Errorf("delay(%d) => %s, want between %s and %s", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestNewRetryPolicyDefaults(t *testing.T) {
+	p := newRetryPolicy(ScanConfiguration{})
+	if p.maxErrors != DefaultMaxHostErrors || p.retries != 0 || p.backoff != DefaultRetryBackoff {
+		t.Errorf("newRetryPolicy() => %#v", p)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{classifyConnectionError(io.EOF), true},
+		{classifyConnectionError(errors.New("ssh: handshake failed: EOF")), true},
+		{classifyConnectionError(errors.New("read tcp 10.0.0.1:22: read: connection reset by peer")), true},
+		{classifyConnectionError(errors.New("dial tcp 10.0.0.1:22: i/o timeout")), true},
+		{classifyConnectionError(errors.New("dial tcp 10.0.0.1:22: connect: connection refused")), true},
+		{pkgerrors.Wrap(classifyConnectionError(io.EOF), "bruteHost"), true},
+		{classifyConnectionError(errors.New("ssh: handshake failed: ssh: overflow reading version string")), false},
+		//Only classified errors are transient
+		{io.EOF, false},
+		{errHostKeyMismatch, false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.expected {
+			t.Errorf("isTransientError(%q) => %v, want %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestBruteHostCircuitBreaker(t *testing.T) {
+	//A server dropping every connection, like sshd over its MaxStartups
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	connections := make(chan struct{}, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connections <- struct{}{}
+			c.Close()
+		}
+	}()
+
+	sr := ScanRequest{hostport: l.Addr().String()}
+	for _, p := range []string{"a", "b", "c", "d"} {
+		sr.credentials = append(sr.credentials, Credential{User: "root", Password: p})
+	}
+	policy := retryPolicy{maxErrors: 3, retries: 1, backoff: time.Millisecond}
+	results := make(chan BruteForceResult, 10)
+	if !bruteHost(context.Background(), sr, results, time.Second, policy) {
+		t.Fatal("bruteHost() => false, want the request finished")
+	}
+	close(results)
+	var errs, deferred []string
+	for br := range results {
+		if br.err != nil {
+			errs = append(errs, br.cred.Password)
+		}
+		if br.deferred != "" {
+			deferred = append(deferred, br.cred.Password)
+		}
+	}
+	//a is retried once, b uses up the budget and c and d are parked
+	if len(errs) != 2 || len(deferred) != 2 || deferred[0] != "c" || deferred[1] != "d" {
+		t.Errorf("bruteHost() errors for %v, deferred %v", errs, deferred)
+	}
+	if len(connections) > 3 {
+		t.Errorf("bruteHost() made %d connections, want at most the budget of 3", len(connections))
+	}
+}
@@ -0,0 +1,264 @@
+package sshauditor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+//Software is the ssh server software and version parsed from a banner
+type Software struct {
+	Product string
+	Version string `json:",omitempty"`
+	//Comment is the text after the software version, which often holds
+	//the distribution's package revision, like Ubuntu-3ubuntu0.10
+	Comment string `json:",omitempty"`
+	//Distro is the distribution named in the comment or version, if any
+	Distro string `json:",omitempty"`
+}
+
+func (s Software) String() string {
+	str := strings.TrimSpace(s.Product + " " + s.Version)
+	if s.Comment != "" {
+		str += " " + s.Comment
+	}
+	return str
+}
+
+//knownProducts maps the lower cased software name from banners to the
+//product name used in the vulnerability feed
+var knownProducts = map[string]string{
+	"openssh":             "OpenSSH",
+	"openssh_for_windows": "OpenSSH",
+	"dropbear":            "Dropbear",
+	"libssh":              "libssh",
+	"cisco":               "Cisco",
+	"romsshell":           "RomSShell",
+	"rosssh":              "RouterOS",
+	"paramiko":            "paramiko",
+	"asyncssh":            "AsyncSSH",
+	"go":                  "Go",
+	"mod_sftp":            "ProFTPD mod_sftp",
+	"serv-u":              "Serv-U",
+	"wingftp":             "Wing FTP",
+}
+
+//knownDistros are the distributions recognised in banner comments
+var knownDistros = []string{
+	"Ubuntu", "Debian", "Raspbian", "FreeBSD", "NetBSD", "OpenBSD", "Windows", "RHEL", "SUSE",
+}
+
+//ParseBanner splits an ssh banner like SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10
+//into the software, version and comment
+func ParseBanner(banner string) Software {
+	var sw Software
+	if !strings.HasPrefix(banner, "SSH-") {
+		return sw
+	}
+	//SSH-protoversion-softwareversion SP comments
+	parts := strings.SplitN(banner, "-", 3)
+	if len(parts) < 3 {
+		return sw
+	}
+	softwareVersion := parts[2]
+	if i := strings.IndexByte(softwareVersion, ' '); i != -1 {
+		sw.Comment = strings.TrimSpace(softwareVersion[i+1:])
+		softwareVersion = softwareVersion[:i]
+	}
+
+	sw.Product, sw.Version = splitSoftwareVersion(softwareVersion)
+	lower := strings.ToLower(sw.Product)
+	if strings.HasPrefix(lower, "openssh_for_windows") {
+		sw.Distro = "Windows"
+	}
+	if p, ok := knownProducts[lower]; ok {
+		sw.Product = p
+	}
+	for _, d := range knownDistros {
+		if strings.Contains(sw.Comment, d) || strings.Contains(sw.Version, d) {
+			sw.Distro = d
+			break
+		}
+	}
+	return sw
+}
+
+//attachSoftware fills in the Software of every host in hosts from its
+//banner
+func attachSoftware(hosts []Host) {
+	for i := range hosts {
+		hosts[i].Software = ParseBanner(hosts[i].Version)
+	}
+}
+
+//splitSoftwareVersion splits softwareversion at the last _ or - that is
+//followed by a digit, so OpenSSH_7.4 is OpenSSH and 7.4.  Software without
+//a version, like ROSSSH, is returned as is.
+func splitSoftwareVersion(s string) (string, string) {
+	for i := len(s) - 2; i > 0; i-- {
+		if (s[i] == '_' || s[i] == '-') && unicode.IsDigit(rune(s[i+1])) {
+			return s[:i], s[i+1:]
+		}
+	}
+	//Banners like SSH-2.0-1.82 sshlib are only a version
+	if s != "" && unicode.IsDigit(rune(s[0])) {
+		return "", s
+	}
+	return s, ""
+}
+
+//versionTokens splits a version into runs of digits and non digits
+func versionTokens(v string) []string {
+	var tokens []string
+	for i := 0; i < len(v); {
+		j := i + 1
+		digit := unicode.IsDigit(rune(v[i]))
+		for j < len(v) && unicode.IsDigit(rune(v[j])) == digit {
+			j++
+		}
+		tokens = append(tokens, v[i:j])
+		i = j
+	}
+	return tokens
+}
+
+//compareVersions compares versions like 7.4p1 and 2020.81, returning -1, 0
+//or 1.  Runs of digits compare numerically and anything else as strings,
+//so 7.10 is newer than 7.9 and 7.4p1 is newer than 7.4.
+func compareVersions(a, b string) int {
+	at, bt := versionTokens(a), versionTokens(b)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		an, aerr := strconv.Atoi(at[i])
+		bn, berr := strconv.Atoi(bt[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case at[i] != bt[i]:
+			if at[i] < bt[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(at) < len(bt):
+		return -1
+	case len(at) > len(bt):
+		return 1
+	}
+	return 0
+}
+
+//Severities of vulnerability feed entries, from least to most severe
+var severities = []string{"low", "medium", "high", "critical"}
+
+//VulnFeedEntry is a vulnerability affecting the versions of a product from
+//Introduced up to but not including Fixed.  Either bound may be empty.
+type VulnFeedEntry struct {
+	ID         string
+	Product    string
+	Introduced string `json:",omitempty"`
+	Fixed      string `json:",omitempty"`
+	Severity   string
+	Summary    string
+	//Backports are distribution builds of affected versions that include
+	//the fix.  A build with the same version and a later comment from the
+	//same distribution is also considered fixed.
+	Backports []Software `json:",omitempty"`
+}
+
+//VulnFeed is a list of known vulnerabilities in ssh server software
+type VulnFeed []VulnFeedEntry
+
+//LoadVulnFeed reads a feed from its JSON form, a list of entries
+func LoadVulnFeed(r io.Reader) (VulnFeed, error) {
+	var f VulnFeed
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadVulnFeed")
+	}
+	for _, e := range f {
+		if e.ID == "" || e.Product == "" {
+			return nil, fmt.Errorf("LoadVulnFeed: entry without an ID or Product: %#v", e)
+		}
+		if severityRank(e.Severity) == -1 {
+			return nil, fmt.Errorf("LoadVulnFeed: %s has unknown severity %q", e.ID, e.Severity)
+		}
+	}
+	return f, nil
+}
+
+//severityRank returns the position of severity in severities, or -1
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+//affects returns true if sw is in the vulnerable range of e and isn't a
+//known backport of the fix
+func (e VulnFeedEntry) affects(sw Software) bool {
+	if sw.Version == "" || !strings.EqualFold(sw.Product, e.Product) {
+		return false
+	}
+	if e.Introduced != "" && compareVersions(sw.Version, e.Introduced) < 0 {
+		return false
+	}
+	if e.Fixed != "" && compareVersions(sw.Version, e.Fixed) >= 0 {
+		return false
+	}
+	for _, b := range e.Backports {
+		if sw.Version == b.Version && sw.Comment != "" && sameDistroBuild(sw.Comment, b.Comment) &&
+			compareVersions(sw.Comment, b.Comment) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//sameDistroBuild returns true if two banner comments are revisions of the
+//same distribution package, like Ubuntu-3ubuntu0.10 and Ubuntu-3ubuntu0.13,
+//differing at most in the final revision number
+func sameDistroBuild(a, b string) bool {
+	at, bt := versionTokens(a), versionTokens(b)
+	if len(at) != len(bt) || len(at) == 0 {
+		return false
+	}
+	for i := 0; i < len(at)-1; i++ {
+		if at[i] != bt[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Match returns the entries affecting sw
+func (f VulnFeed) Match(sw Software) []VulnFeedEntry {
+	var matches []VulnFeedEntry
+	for _, e := range f {
+		if e.affects(sw) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+//SoftwareFinding is a vulnerability in the software an active host runs
+type SoftwareFinding struct {
+	Host     Host
+	Software Software
+	VulnFeedEntry
+}
@@ -0,0 +1,158 @@
+package sshauditor
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseBanner(t *testing.T) {
+	var testCases = []struct {
+		banner   string
+		expected Software
+	}{
+		{"SSH-2.0-OpenSSH_7.4", Software{Product: "OpenSSH", Version: "7.4"}},
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10", Software{Product: "OpenSSH", Version: "8.9p1", Comment: "Ubuntu-3ubuntu0.10", Distro: "Ubuntu"}},
+		{"SSH-2.0-OpenSSH_7.4p1 Debian-10+deb9u7", Software{Product: "OpenSSH", Version: "7.4p1", Comment: "Debian-10+deb9u7", Distro: "Debian"}},
+		{"SSH-2.0-OpenSSH_for_Windows_8.1", Software{Product: "OpenSSH", Version: "8.1", Distro: "Windows"}},
+		{"SSH-2.0-dropbear_2020.81", Software{Product: "Dropbear", Version: "2020.81"}},
+		{"SSH-2.0-dropbear", Software{Product: "Dropbear"}},
+		{"SSH-2.0-libssh-0.6.3", Software{Product: "libssh", Version: "0.6.3"}},
+		{"SSH-2.0-libssh_0.9.6", Software{Product: "libssh", Version: "0.9.6"}},
+		{"SSH-1.99-Cisco-1.25", Software{Product: "Cisco", Version: "1.25"}},
+		{"SSH-2.0-RomSShell_4.31", Software{Product: "RomSShell", Version: "4.31"}},
+		{"SSH-2.0-ROSSSH", Software{Product: "RouterOS"}},
+		{"SSH-2.0-1.82 sshlib: WinSSHD 4.28", Software{Version: "1.82", Comment: "sshlib: WinSSHD 4.28"}},
+		{"HTTP/1.1 400 Bad Request", Software{}},
+	}
+	for _, tt := range testCases {
+		if got := ParseBanner(tt.banner); got != tt.expected {
+			t.Errorf("ParseBanner(%q) => %#v, want %#v", tt.banner, got, tt.expected)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	var testCases = []struct {
+		a, b     string
+		expected int
+	}{
+		{"7.4", "7.4", 0},
+		{"7.4", "7.4p1", -1},
+		{"7.10", "7.9", 1},
+		{"8.9p1", "9.8p1", -1},
+		{"2020.81", "2017.75", 1},
+		{"Ubuntu-3ubuntu0.13", "Ubuntu-3ubuntu0.10", 1},
+	}
+	for _, tt := range testCases {
+		if got := compareVersions(tt.a, tt.b); got != tt.expected {
+			t.Errorf("compareVersions(%q, %q) => %d, want %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestVulnFeed(t *testing.T) {
+	f, err := os.Open("../feeds/ssh-vulns.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	feed, err := LoadVulnFeed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		banner   string
+		expected string
+	}{
+		{"SSH-2.0-OpenSSH_9.8p1", ""},
+		{"SSH-2.0-OpenSSH_9.6p1", "CVE-2024-6387"},
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6", "CVE-2024-6387"},
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10", ""},
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.13", ""},
+		{"SSH-2.0-OpenSSH_8.4p1 Debian-5+deb11u3", "CVE-2021-41617"},
+		{"SSH-2.0-OpenSSH_7.4", "CVE-2021-41617,CVE-2018-15473"},
+		{"SSH-2.0-libssh-0.7.5", "CVE-2018-10933"},
+		{"SSH-2.0-libssh_0.8.4", ""},
+		{"SSH-2.0-dropbear_2016.73", "CVE-2016-7406,CVE-2017-9078"},
+		{"SSH-2.0-dropbear", ""},
+	}
+	for _, tt := range testCases {
+		var ids []string
+		for _, e := range feed.Match(ParseBanner(tt.banner)) {
+			ids = append(ids, e.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.expected {
+			t.Errorf("Match(%q) => %s, want %s", tt.banner, got, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{`{}`, `[{"ID": "CVE-1", "Product": "OpenSSH", "Severity": "dire"}]`, `[{"ID": "CVE-1", "Severity": "low"}]`} {
+		if _, err := LoadVulnFeed(strings.NewReader(bad)); err == nil {
+			t.Errorf("LoadVulnFeed(%s) did not return an error", bad)
+		}
+	}
+}
+
+func TestSoftwareVulnerabilities(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []SSHHost{
+		{hostport: "192.168.1.1:22", version: "SSH-2.0-OpenSSH_9.9p1"},
+		{hostport: "192.168.1.2:22", version: "SSH-2.0-OpenSSH_7.9"},
+		{hostport: "192.168.1.3:22", version: "SSH-2.0-libssh-0.7.5"},
+	} {
+		if err := s.addOrUpdateHost(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := New(s)
+	findings, err := a.SoftwareVulnerabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("SoftwareVulnerabilities() without a feed => %#v", findings)
+	}
+	a.SetVulnFeed(VulnFeed{
+		{ID: "CVE-A", Product: "OpenSSH", Fixed: "8.0", Severity: "medium"},
+		{ID: "CVE-B", Product: "libssh", Fixed: "0.7.6", Severity: "critical"},
+	})
+	findings, err = a.SoftwareVulnerabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 || findings[0].ID != "CVE-B" || findings[1].Host.Hostport != "192.168.1.2:22" ||
+		findings[1].Software.Version != "7.9" {
+		t.Errorf("SoftwareVulnerabilities() => %#v", findings)
+	}
+}
+
+func TestHostSoftware(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	err = s.addOrUpdateHost(SSHHost{hostport: "10.0.0.1:22", keyfp: "a", version: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Hosts come with the software parsed from their banner, for dupes and
+	//the reports
+	hosts, err := s.GetActiveHosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Software{Product: "OpenSSH", Version: "8.9p1", Comment: "Ubuntu-3ubuntu0.10", Distro: "Ubuntu"}
+	if len(hosts) != 1 || hosts[0].Software != expected {
+		t.Errorf("GetActiveHosts() => %+v, want software %+v", hosts, expected)
+	}
+}
@@ -1,12 +1,19 @@
 package sshauditor
 
 import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -35,13 +42,19 @@ func isPrivateKey(s string) bool {
 //DialWithDeadline is identical to ssh.Dial except that it calls SetDeadline on
 //the underlying connection
 func DialWithDeadline(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	return dialWithDeadline(network, addr, config, 2*config.Timeout)
+}
+
+//dialWithDeadline is DialWithDeadline with the time the connection may be
+//used for given by deadline
+func dialWithDeadline(network, addr string, config *ssh.ClientConfig, deadline time.Duration) (*ssh.Client, error) {
 	conn, err := net.DialTimeout(network, addr, config.Timeout)
 	if err != nil {
 		return nil, err
 	}
 
 	//This call to SetDeadline is the only difference from ssh.Dial
-	conn.SetDeadline(time.Now().Add(2 * config.Timeout))
+	conn.SetDeadline(time.Now().Add(deadline))
 	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
 		return nil, err
@@ -49,39 +62,99 @@ func DialWithDeadline(network, addr string, config *ssh.ClientConfig) (*ssh.Clie
 	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func FetchSSHKeyFingerprint(hostport string) string {
+//hostKeyAlgorithms are the host key algorithms FetchSSHHostKeys negotiates,
+//in the order the Go client prefers them.  The rsa-sha2 algorithms sign
+//with the same ssh-rsa key, so servers that dropped ssh-rsa signatures
+//still report their RSA key.
+var hostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	ssh.KeyAlgoED25519,
+}
 
-	var keyFingerprint string
+//errHostKeyFetched aborts a handshake once the host key has been received
+var errHostKeyFetched = errors.New("host key fetched")
 
-	DumpHostkey := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		fp := ssh.FingerprintSHA256(key)
-		keyFingerprint = fp
-		return nil
+//keyBits returns the size of a public key in bits, or 0 if it is unknown
+func keyBits(key ssh.PublicKey) int {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
 	}
-
-	user := "security"
-	host, _, err := net.SplitHostPort(hostport)
-	if err == nil {
-		user = fmt.Sprintf("logcheck-%s", host)
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *dsa.PublicKey:
+		return k.P.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
 	}
+	return 0
+}
 
+//fetchHostKey negotiates a connection to hostport offering only the
+//host key algorithm algo and returns the key the server presented
+func fetchHostKey(hostport, algo string, timeout time.Duration) (HostKey, error) {
+	var hk HostKey
 	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password("security"),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hk = HostKey{
+				Hostport:    hostport,
+				Type:        key.Type(),
+				Fingerprint: ssh.FingerprintSHA256(key),
+				Bits:        keyBits(key),
+			}
+			return errHostKeyFetched
 		},
-		HostKeyCallback: DumpHostkey,
-		Timeout:         4 * time.Second,
-		ClientVersion:   "SSH-2.0-Go-ssh-auditor",
+		HostKeyAlgorithms: []string{algo},
+		Timeout:           timeout,
+		ClientVersion:     "SSH-2.0-Go-ssh-auditor",
 	}
-
 	client, err := DialWithDeadline("tcp", hostport, config)
 	if err == nil {
-		//This was supposed to fail
 		client.Close()
-		log.Error("initial probe worked!?!?", "host", hostport, "user", user, "password", "security")
 	}
-	return keyFingerprint
+	if hk.Type != "" {
+		return hk, nil
+	}
+	return hk, err
+}
+
+//FetchSSHHostKeys returns every host key hostport offers, by negotiating
+//once per host key algorithm.  offered are the host key algorithms the
+//server listed in its KEXINIT, only those are negotiated unless it is
+//empty.  The keys are in the order the Go client prefers them, so the first
+//is the one a default connection would see.  A key negotiated by several
+//algorithms, like an RSA key behind rsa-sha2-512 and ssh-rsa, is returned
+//once with its key type.
+func FetchSSHHostKeys(hostport string, offered []string, timeout time.Duration) []HostKey {
+	var keys []HostKey
+	seen := make(map[string]bool)
+	for _, algo := range hostKeyAlgorithms {
+		if len(offered) != 0 && !containsAny(offered, []string{algo}) {
+			continue
+		}
+		hk, err := fetchHostKey(hostport, algo, timeout)
+		if err != nil {
+			log.Debug("host key algorithm not offered", "host", hostport, "algorithm", algo, "err", err)
+			continue
+		}
+		if seen[hk.Fingerprint] {
+			continue
+		}
+		seen[hk.Fingerprint] = true
+		keys = append(keys, hk)
+	}
+	return keys
 }
 
 func SSHExecAttempt(client *ssh.Client, hostport string) bool {
@@ -127,66 +200,197 @@ func SSHDialAttempt(client *ssh.Client, dest string) bool {
 	return false
 }
 
-func challengeReponder(password string) ssh.KeyboardInteractiveChallenge {
-	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-		answers := make([]string, len(questions))
-		for i := range answers {
-			answers[i] = password
-		}
-		return answers, nil
-	}
+//errHostKeyMismatch aborts a handshake with a host presenting a key that
+//wasn't seen during discovery
+var errHostKeyMismatch = errors.New("host key does not match the discovered keys")
+
+//hostKeyPin only accepts host keys with one of the pinned fingerprints, and
+//records any other key presented
+type hostKeyPin struct {
+	fingerprints []string
+	//presented is the type and fingerprint of a rejected key
+	presented string
 }
 
-func genAuthMethod(password string) ([]ssh.AuthMethod, error) {
-	if isPrivateKey(password) {
-		signer, err := ssh.ParsePrivateKey([]byte(password))
-		if err != nil {
-			return []ssh.AuthMethod{}, err
+func (p *hostKeyPin) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fp := ssh.FingerprintSHA256(key)
+	for _, f := range p.fingerprints {
+		if f == fp {
+			return nil
 		}
+	}
+	p.presented = key.Type() + " " + fp
+	return errHostKeyMismatch
+}
 
-		return []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		}, nil
+//authOptions are the optional settings of sshAuthAttempt
+type authOptions struct {
+	//trustCodePrompt, see authRecorder.mfaBlocked
+	trustCodePrompt bool
+	//hostKeyCallback verifies the host key, any key is accepted if unset
+	hostKeyCallback ssh.HostKeyCallback
+}
 
+func (o authOptions) hostKeyCheck() ssh.HostKeyCallback {
+	if o.hostKeyCallback == nil {
+		return ssh.InsecureIgnoreHostKey()
 	}
-	return []ssh.AuthMethod{
-		ssh.Password(password),
-		ssh.KeyboardInteractive(challengeReponder(password)),
-	}, nil
+	return o.hostKeyCallback
 }
 
+//SSHAuthAttempt tries a user and password or private key against hostport.
+//It returns what the login allowed, or an empty string if the credential was
+//rejected.  Failed connections return a ConnectionError.
 func SSHAuthAttempt(hostport, user, password string, timeout time.Duration) (string, error) {
-	authMethods, err := genAuthMethod(password)
+	result, _, err := sshAuthAttempt(hostport, user, password, timeout, authOptions{})
+	return result, err
+}
+
+//sshAuthAttempt is SSHAuthAttempt that also returns the keyboard-interactive
+//prompts the server sent.  Failed connections return a ConnectionError.
+func sshAuthAttempt(hostport, user, password string, timeout time.Duration, opts authOptions) (string, []string, error) {
+	r := newAuthRecorder(password)
+	authMethods, err := r.authMethods()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	config := &ssh.ClientConfig{
 		User:            user,
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: opts.hostKeyCheck(),
 		Timeout:         timeout,
 		ClientVersion:   "SSH-2.0-Go-ssh-auditor",
 	}
 	client, err := DialWithDeadline("tcp", hostport, config)
 	if err != nil {
-		//FIXME: better way?
-		if strings.Contains(err.Error(), "unable to authenticate") {
-			return "", nil
+		if r.mfaBlocked(err, opts.trustCodePrompt) {
+			return ResultMFABlocked, r.prompts, nil
+		}
+		//A host that didn't let the credential be sent didn't reject it
+		if isAuthFailure(err) && !authMethodMismatch(err) {
+			return "", r.prompts, nil
 		}
-		return "", err
+		return "", r.prompts, classifyConnectionError(err)
 	}
 	//Found a potential weak password!
 	defer client.Close()
+	return classifyLogin(client, hostport), r.prompts, nil
+}
 
+//classifyLogin returns what a successful login to hostport allows, "exec",
+//"tunnel" or just "auth"
+func classifyLogin(client *ssh.Client, hostport string) string {
 	execSuccess := SSHExecAttempt(client, hostport)
 	if execSuccess {
-		return "exec", nil
+		return "exec"
 	}
 	//If I was able to authenticate but was unable to run a command, see if port forwarding works
 
 	tcpSuccess := SSHDialAttempt(client, hostport)
 	if tcpSuccess {
-		return "tunnel", nil
+		return "tunnel"
+	}
+	return "auth"
+}
+
+//isAuthFailure returns true if a dial failed because every authentication
+//method was rejected
+func isAuthFailure(err error) bool {
+	//FIXME: better way?
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+//Ways a host can let in a client that knows no valid credential
+const (
+	AnythingNone     = "none auth"
+	AnythingPassword = "any password"
+)
+
+//Access given by a host that lets anything in
+const (
+	//AccessOpen is a real shell, id printed a uid
+	AccessOpen = "open access"
+	//AccessShellLogin is a login that didn't give a usable shell, like a
+	//device asking for credentials in its own shell, or a honeypot
+	AccessShellLogin = "shell-based login or honeypot"
+)
+
+//randomCredential returns a user and password no real host accepts
+func randomCredential() (Credential, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Credential{}, err
+	}
+	return Credential{User: "sa-" + hex.EncodeToString(b[:4]), Password: hex.EncodeToString(b[4:])}, nil
+}
+
+//classifyAccess runs id on client to tell an open shell from a login that
+//only looks successful
+func classifyAccess(client *ssh.Client) string {
+	session, err := client.NewSession()
+	if err != nil {
+		return AccessShellLogin
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput("id")
+	if err != nil || isFalsePositiveBanner(string(out)) || !strings.Contains(string(out), "uid=") {
+		return AccessShellLogin
+	}
+	return AccessOpen
+}
+
+//SSHAcceptsAnything checks if hostport lets in a client using none
+//authentication or a random user and password.  It returns how the host
+//was entered and the access it gave, like "any password: open access", or
+//an empty string if both were rejected.
+func SSHAcceptsAnything(hostport string, timeout time.Duration) (string, error) {
+	anything, _, _, err := acceptsAnything(hostport, timeout, nil)
+	return anything, err
+}
+
+//acceptsAnything is SSHAcceptsAnything that also returns if the random
+//password was followed by a prompt for a second factor, which means the
+//host's code prompts say nothing about the password, and how many
+//connections it made.  hostKeyCallback verifies the host key as in
+//authOptions.
+func acceptsAnything(hostport string, timeout time.Duration, hostKeyCallback ssh.HostKeyCallback) (string, bool, int, error) {
+	cred, err := randomCredential()
+	if err != nil {
+		return "", false, 0, err
+	}
+	r := newAuthRecorder(cred.Password)
+	passwordAuth, err := r.authMethods()
+	if err != nil {
+		return "", false, 0, err
+	}
+	conns := 0
+	attempts := []struct {
+		how  string
+		auth []ssh.AuthMethod
+	}{
+		//The client always tries none before any configured method
+		{AnythingNone, nil},
+		{AnythingPassword, passwordAuth},
+	}
+	for _, a := range attempts {
+		config := &ssh.ClientConfig{
+			User:            cred.User,
+			Auth:            a.auth,
+			HostKeyCallback: authOptions{hostKeyCallback: hostKeyCallback}.hostKeyCheck(),
+			Timeout:         timeout,
+			ClientVersion:   "SSH-2.0-Go-ssh-auditor",
+		}
+		client, err := DialWithDeadline("tcp", hostport, config)
+		conns++
+		if err != nil {
+			if isAuthFailure(err) {
+				continue
+			}
+			return "", false, conns, classifyConnectionError(err)
+		}
+		access := classifyAccess(client)
+		client.Close()
+		return a.how + ": " + access, false, conns, nil
 	}
-	return "auth", nil
+	return "", r.codeAfterPassword, conns, nil
 }
@@ -1,10 +1,19 @@
 package sshauditor
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type authTestCase struct {
@@ -82,7 +91,7 @@ func TestSSHAuthAttempt(t *testing.T) {
 	}
 	for _, tt := range authTestCases {
 		t.Run(fmt.Sprintf("SSHAuthAttempt(%q, %q, %q) => %q", tt.hostport, tt.user, tt.password, tt.expected), func(t *testing.T) {
-			resp, err := SSHAuthAttempt(tt.hostport, tt.user, tt.password)
+			resp, err := SSHAuthAttempt(tt.hostport, tt.user, tt.password, 4*time.Second)
 			if err != nil && tt.wanterr != true {
 				t.Errorf("Unexpected error %v", err)
 			}
@@ -95,3 +104,212 @@ func TestSSHAuthAttempt(t *testing.T) {
 		})
 	}
 }
+
+//newTestSigners returns freshly generated rsa, ecdsa and ed25519 host keys
+func newTestSigners(t *testing.T) []ssh.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signers []ssh.Signer
+	for _, k := range []interface{}{rsaKey, ecdsaKey, ed25519Key} {
+		signer, err := ssh.NewSignerFromKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+//listenSSH starts an ssh server on localhost offering the given host keys
+//that rejects every login, and returns its address
+func listenSSH(t *testing.T, signers ...ssh.Signer) (string, func()) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	for _, s := range signers {
+		config.AddHostKey(s)
+	}
+	return listenSSHConfig(t, config)
+}
+
+//listenSSHConfig starts an ssh server on localhost using config, which must
+//have a host key, and returns its address
+func listenSSHConfig(t testing.TB, config *ssh.ServerConfig) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestFetchSSHHostKeys(t *testing.T) {
+	signers := newTestSigners(t)
+	hostport, stop := listenSSH(t, signers...)
+	defer stop()
+
+	algos, err := FetchSSHAlgorithms(hostport, 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := FetchSSHHostKeys(hostport, algos.HostKey, 4*time.Second)
+	//In the order the Go client prefers them
+	expected := []struct {
+		signer ssh.Signer
+		bits   int
+	}{
+		{signers[1], 384},
+		{signers[0], 1024},
+		{signers[2], 256},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("FetchSSHHostKeys() => %#v, want %d keys", keys, len(expected))
+	}
+	for i, e := range expected {
+		pub := e.signer.PublicKey()
+		want := HostKey{
+			Hostport:    hostport,
+			Type:        pub.Type(),
+			Fingerprint: ssh.FingerprintSHA256(pub),
+			Bits:        e.bits,
+		}
+		if keys[i] != want {
+			t.Errorf("key %d => %#v, want %#v", i, keys[i], want)
+		}
+	}
+
+	//Only the offered algorithms are negotiated
+	keys = FetchSSHHostKeys(hostport, []string{ssh.KeyAlgoED25519}, 4*time.Second)
+	if len(keys) != 1 || keys[0].Type != ssh.KeyAlgoED25519 {
+		t.Errorf("FetchSSHHostKeys(ed25519) => %#v, want the ed25519 key", keys)
+	}
+}
+
+func TestFetchSSHHostKeysRSASHA2(t *testing.T) {
+	signers := newTestSigners(t)
+	hostport, stop := listenSSH(t, signers[0])
+	defer stop()
+
+	//A server that dropped ssh-rsa signatures, like OpenSSH 8.8, only
+	//lists the rsa-sha2 algorithms in its KEXINIT
+	offered := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+	keys := FetchSSHHostKeys(hostport, offered, 4*time.Second)
+	pub := signers[0].PublicKey()
+	want := HostKey{
+		Hostport:    hostport,
+		Type:        ssh.KeyAlgoRSA,
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		Bits:        1024,
+	}
+	if len(keys) != 1 || keys[0] != want {
+		t.Errorf("FetchSSHHostKeys(rsa-sha2) => %#v, want %#v", keys, want)
+	}
+}
+
+//listenSSHShell is like listenSSHConfig, but answers every exec request on
+//a session with output
+func listenSSHShell(t *testing.T, config *ssh.ServerConfig, output string) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveSession := func(newChan ssh.NewChannel) {
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+		for req := range reqs {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			ch.Write([]byte(output))
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sconn.Close()
+				go ssh.DiscardRequests(reqs)
+				for newChan := range chans {
+					if newChan.ChannelType() != "session" {
+						newChan.Reject(ssh.UnknownChannelType, "")
+						continue
+					}
+					go serveSession(newChan)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestSSHAcceptsAnything(t *testing.T) {
+	signer := newTestSigners(t)[2]
+	anyPassword := func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		return nil, nil
+	}
+	tests := []struct {
+		name     string
+		config   *ssh.ServerConfig
+		output   string
+		expected string
+	}{
+		{"rejects", &ssh.ServerConfig{PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("denied")
+		}}, "", ""},
+		{"none", &ssh.ServerConfig{NoClientAuth: true}, "uid=0(root) gid=0(root)\n", AnythingNone + ": " + AccessOpen},
+		{"password", &ssh.ServerConfig{PasswordCallback: anyPassword}, "uid=0(root) gid=0(root)\n", AnythingPassword + ": " + AccessOpen},
+		{"shell login", &ssh.ServerConfig{PasswordCallback: anyPassword}, "login: ", AnythingPassword + ": " + AccessShellLogin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.AddHostKey(signer)
+			hostport, stop := listenSSHShell(t, tt.config, tt.output)
+			defer stop()
+			anything, err := SSHAcceptsAnything(hostport, 4*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if anything != tt.expected {
+				t.Errorf("SSHAcceptsAnything() => %q, want %q", anything, tt.expected)
+			}
+		})
+	}
+}
@@ -1,36 +1,68 @@
 package sshauditor
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
 
 type SSHHost struct {
 	hostport string
 	version  string
 	keyfp    string
+	keys     []HostKey
+	algos    SSHAlgorithms
+	names    string
+	ptrNames string
+	//authMethods are nil if they couldn't be fetched
+	authMethods []string
 }
 
-func keyworker(jobs <-chan ScanResult, results chan<- SSHHost) {
+//defaultDiscoveryTimeout is the connection timeout used when the scan
+//configuration doesn't have one
+const defaultDiscoveryTimeout = 4 * time.Second
+
+func keyworker(ctx context.Context, jobs <-chan ScanResult, results chan<- SSHHost, resolver Resolver, timeout time.Duration) {
 	for host := range jobs {
-		if !host.success {
+		if !host.success || ctx.Err() != nil {
 			continue
 		}
+		algos, err := FetchSSHAlgorithms(host.hostport, timeout)
+		if err != nil {
+			log.Debug("unable to fetch algorithms", "host", host.hostport, "err", err)
+		}
 		res := SSHHost{
 			hostport: host.hostport,
 			version:  host.banner,
-			keyfp:    FetchSSHKeyFingerprint(host.hostport),
+			keys:     FetchSSHHostKeys(host.hostport, algos.HostKey, timeout),
+			algos:    algos,
+			ptrNames: lookupPTR(resolver, host.hostport),
+		}
+		if len(res.keys) > 0 {
+			res.keyfp = res.keys[0].Fingerprint
+		}
+		res.authMethods, err = FetchSSHAuthMethods(host.hostport, timeout)
+		if err != nil {
+			log.Debug("unable to fetch auth methods", "host", host.hostport, "err", err)
 		}
 		results <- res
 	}
 }
 
-func fingerPrintFetcher(numWorkers int, scanResults <-chan ScanResult) chan SSHHost {
+func fingerPrintFetcher(ctx context.Context, numWorkers int, scanResults <-chan ScanResult, resolver Resolver, timeout time.Duration) chan SSHHost {
 	var wg sync.WaitGroup
+	if timeout <= 0 {
+		timeout = defaultDiscoveryTimeout
+	}
 
 	results := make(chan SSHHost, 1024)
 
 	for w := 0; w <= numWorkers; w++ {
 		wg.Add(1)
 		go func() {
-			keyworker(scanResults, results)
+			keyworker(ctx, scanResults, results, resolver, timeout)
 			wg.Done()
 		}()
 	}
@@ -3,85 +3,155 @@ package sshauditor
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS hosts (
-	hostport character varying,
-	version character varying,
-	fingerprint character varying,
-	seen_first REAL,
-	seen_last REAL,
-
-	PRIMARY KEY (hostport)
-);
-
-CREATE TABLE IF NOT EXISTS credentials (
-	user character varying,
-	password character varying,
-	scan_interval DEFAULT 14,
-
-	PRIMARY KEY (user, password)
-);
-
-CREATE TABLE IF NOT EXISTS host_creds (
-	hostport character varying,
-	user character varying,
-	password character varying,
-	last_tested REAL,
-	result character varying,
-	scan_interval DEFAULT 0,
-
-	PRIMARY KEY (hostport, user, password)
-);
-
-CREATE TABLE IF NOT EXISTS host_changes (
-	time REAL,
-	hostport character varying,
-	type character varying,
-	old character varying,
-	new character varying
-);
-
--- Migrate
-PRAGMA writable_schema=1;
-UPDATE sqlite_master SET SQL=REPLACE(SQL, 'priority', 'scan_interval') WHERE name='host_creds';
-UPDATE sqlite_master SET SQL=REPLACE(SQL, 'priority', 'scan_interval') WHERE name='credentials';
-PRAGMA writable_schema=0;
--- UPDATE credentials set scan_interval=14 where scan_interval == 0;
--- UPDATE host_creds set scan_interval=14 where scan_interval == 0;
-CREATE INDEX IF NOT EXISTS host_creds_vulnerable ON host_creds (result) WHERE result != '';
-`
-
 type Host struct {
 	Hostport    string
 	Version     string
 	Fingerprint string
 	SeenFirst   string `db:"seen_first"`
 	SeenLast    string `db:"seen_last"`
+	//Names are the hostnames the host was discovered under and PTRNames
+	//its reverse DNS, both comma separated
+	Names    string
+	PTRNames string `db:"ptr_names"`
+	//The algorithms offered in the host's KEXINIT, comma separated
+	KexAlgorithms     string `db:"kex_algorithms"`
+	HostKeyAlgorithms string `db:"host_key_algorithms"`
+	Ciphers           string
+	MACs              string `db:"macs"`
+	//AuthMethods are the authentication methods the host advertises,
+	//comma separated, or empty if they are unknown
+	AuthMethods string `db:"auth_methods"`
+	//KeyMismatch is when a scan was last presented a host key that wasn't
+	//seen during discovery, credentials aren't sent to the host until a
+	//discovery accepts its keys
+	KeyMismatch string `db:"key_mismatch"`
+	//RateLimit is how many connections the host answered before it started
+	//blocking them, scans stay under it.  0 if it never did.
+	RateLimit int `db:"rate_limit"`
+	//Probed is when the host was last checked for accepting any
+	//credential, it is cleared when the host's keys or version change.
+	//ProbeCodePrompt is set if the random password got a second factor
+	//prompt.
+	Probed          string
+	ProbeCodePrompt bool `db:"probe_code_prompt"`
+	//Keys are the host keys of every type the host offered
+	Keys []HostKey `db:"-" json:",omitempty"`
+	//Software is what the store parsed from Version when it loaded the
+	//host
+	Software Software `db:"-"`
+}
+
+//splitNames reverses the comma joining of stored name lists
+func splitNames(names string) []string {
+	if names == "" {
+		return nil
+	}
+	return strings.Split(names, ",")
+}
+
+//Algorithms returns the algorithms the host offered when last discovered
+func (h Host) Algorithms() SSHAlgorithms {
+	return SSHAlgorithms{
+		Kex:     splitNames(h.KexAlgorithms),
+		HostKey: splitNames(h.HostKeyAlgorithms),
+		Ciphers: splitNames(h.Ciphers),
+		MACs:    splitNames(h.MACs),
+	}
+}
+
+//SupportedAuthMethods returns the authentication methods the host advertised
+//when last discovered
+func (h Host) SupportedAuthMethods() []string {
+	return splitNames(h.AuthMethods)
+}
+
+//HostKey is one of the host keys a host offers.  A host has at most one key
+//of each type.
+type HostKey struct {
+	Hostport    string `json:"-"`
+	Type        string
+	Fingerprint string
+	Bits        int
+	SeenFirst   string `db:"seen_first"`
+	SeenLast    string `db:"seen_last"`
 }
 
 type Credential struct {
 	User         string
 	Password     string
 	ScanInterval int `db:"scan_interval"`
+	//Rules restrict the hosts the credential is tried against, one
+	//type=pattern CredentialRule per line
+	Rules string `json:",omitempty"`
 }
 
 func (c Credential) String() string {
 	return fmt.Sprintf("%s:%s every %d days", c.User, c.Password, c.ScanInterval)
 }
 
+//Vulnerability states tracked in HostCredential.State
+const (
+	VulnOpen     = "open"
+	VulnFixed    = "fixed"
+	VulnReopened = "reopened"
+)
+
 type HostCredential struct {
 	Hostport     string `json:"-"`
 	User         string
 	Password     string
 	LastTested   string `db:"last_tested"`
 	Result       string
-	ScanInterval int `db:"scan_interval"`
+	ScanInterval int           `db:"scan_interval"`
+	ScanRunID    sql.NullInt64 `db:"scan_run_id" json:"-"`
+
+	//State is empty until the credential has worked at least once, then
+	//moves between open, fixed and reopened
+	State           string
+	FirstDetected   string `db:"first_detected"`
+	LastConfirmed   string `db:"last_confirmed"`
+	RemediatedAt    string `db:"remediated_at"`
+	ConfirmedResult string `db:"confirmed_result"`
+
+	//Prompts are the keyboard-interactive prompts the server sent when
+	//the credential was last tested with any, one per line
+	Prompts string
+
+	//ErrorCount is how many times in a row trying the credential failed
+	//with a ConnectionError, ErrorType, LastError and LastErrorAt are the
+	//type, message and time of the last one.  They are reset once the
+	//credential is tested.
+	ErrorType   string `db:"error_type"`
+	LastError   string `db:"last_error"`
+	LastErrorAt string `db:"last_error_at"`
+	ErrorCount  int    `db:"error_count"`
+
+	//Deferred is why the last run that got to the credential left it for
+	//the next one, one of the DeferredX constants, and DeferredAt when.
+	//They are reset once the credential is tried.
+	Deferred   string
+	DeferredAt string `db:"deferred_at"`
+}
+
+//TimeToRemediate returns how long a fixed vulnerability was open for,
+//from when it was first detected until it was remediated
+func (hc HostCredential) TimeToRemediate() time.Duration {
+	first, err := time.Parse(timestampFormat, hc.FirstDetected)
+	if err != nil {
+		return 0
+	}
+	fixed, err := time.Parse(timestampFormat, hc.RemediatedAt)
+	if err != nil {
+		return 0
+	}
+	return fixed.Sub(first)
 }
 
 type Vulnerability struct {
@@ -89,30 +159,243 @@ type Vulnerability struct {
 	Host `db:"host"`
 }
 
-type SQLiteStore struct {
+//Host finding types
+const (
+	//FindingTerrapin is a host exposed to CVE-2023-48795, the detail lists
+	//the affected cipher modes
+	FindingTerrapin = "terrapin"
+	//FindingPasswordAuth is a host that accepts passwords, the detail lists
+	//the methods that do
+	FindingPasswordAuth = "password-auth"
+	//FindingAcceptsAnything is a host that lets in none auth or a random
+	//password, the detail is how and the access it gave
+	FindingAcceptsAnything = "accepts-anything"
+	//FindingRateLimited is a host that started blocking connections during
+	//a scan, like fail2ban or sshd's MaxStartups do, the detail is after
+	//how many
+	FindingRateLimited = "rate-limited"
+)
+
+//HostFinding is a problem with a host itself rather than one of its
+//credentials.  A host has at most one finding of each type.
+type HostFinding struct {
+	Hostport   string `json:"-"`
+	Type       string
+	Detail     string
+	FirstFound string `db:"first_found"`
+	LastFound  string `db:"last_found"`
+}
+
+//Finding is a HostFinding along with the host it was found on
+type Finding struct {
+	HostFinding
+	Host `db:"host"`
+}
+
+//HostErrors is a host whose credentials fail with connection errors or have
+//never been tested
+type HostErrors struct {
+	Hostport string
+	Version  string
+	//Credentials is how many credentials are queued for the host, Tested
+	//how many of them were tested since they were queued and Failing how
+	//many failed with a ConnectionError the last time they were tried
+	Credentials int
+	Tested      int
+	Failing     int
+	//Errors is how many credentials are failing with each error type
+	Errors map[string]int `json:",omitempty"`
+	//MaxErrorCount is the most errors in a row of any credential and
+	//LastError the message of the most recent error of any of them
+	MaxErrorCount int    `json:",omitempty"`
+	LastError     string `json:",omitempty"`
+	//Deferred is how many credentials were left for a later run for each
+	//DeferredX reason, and LastDeferred when that last happened
+	Deferred     map[string]int `json:",omitempty"`
+	LastDeferred string         `json:",omitempty"`
+}
+
+type HostChange struct {
+	Time      string
+	Hostport  string
+	Type      string
+	Old       string
+	New       string
+	ScanRunID sql.NullInt64 `db:"scan_run_id" json:"-"`
+}
+
+//ScanRun records a single invocation of discover, scan, rescan or logcheck
+//along with the configuration it ran with and what it found
+type ScanRun struct {
+	ID           int64
+	Type         string
+	Started      string
+	Finished     string
+	Include      string
+	Exclude      string
+	Ports        string
+	Concurrency  int
+	TimeoutMs    int `db:"timeout_ms"`
+	TotalCount   int `db:"total_count"`
+	NegCount     int `db:"neg_count"`
+	PosCount     int `db:"pos_count"`
+	ErrCount     int `db:"err_count"`
+	NewCount     int `db:"new_count"`
+	UpdatedCount int `db:"updated_count"`
+	//Interrupted is when the run was canceled before it completed, it is
+	//cleared if a resumed scan or rescan completes it
+	Interrupted string
+	//DeferredCount is how many credentials weren't tried because their
+	//host returned too many errors
+	DeferredCount int `db:"deferred_count"`
+	//Failed is the error a run stopped with, if it did
+	Failed string
+}
+
+func newScanRun(runType string, cfg ScanConfiguration) ScanRun {
+	return ScanRun{
+		Type:        runType,
+		Include:     strings.Join(cfg.Include, ","),
+		Exclude:     strings.Join(cfg.Exclude, ","),
+		Ports:       joinInts(cfg.Ports, ","),
+		Concurrency: cfg.Concurrency,
+		TimeoutMs:   int(cfg.Timeout / time.Millisecond),
+	}
+}
+
+//Store is the interface SSHAuditor uses to persist hosts, credentials and
+//results.  Begin and Commit nest, so a caller can wrap several store calls
+//in a single transaction.
+type Store interface {
+	Init() error
+	Close() error
+	SchemaVersion() (int, int, error)
+
+	IsEncrypted() (bool, error)
+	Unlock(passphrase []byte) error
+	Encrypt(passphrase []byte) error
+	Rekey(newPassphrase []byte) error
+	Begin() (*sqlx.Tx, error)
+	Commit() error
+
+	AddCredential(c Credential) (bool, error)
+	GetAllCreds() ([]Credential, error)
+	ResetCreds() error
+	ResetInterval() error
+	GetActiveHosts(maxAgeDays int) ([]Host, error)
+	DeleteHost(hostport string) error
+	ClearHostRateLimit(hostport string) error
+	GetVulnerabilities() ([]Vulnerability, error)
+	GetFixedVulnerabilities() ([]Vulnerability, error)
+	GetFindings() ([]Finding, error)
+	GetHostErrors() ([]HostErrors, error)
+	GetScanRuns(limit int) ([]ScanRun, error)
+	GetScanRun(id int64) (ScanRun, error)
+	GetScanRunChanges(id int64) ([]HostChange, error)
+	GetScanRunVulnerabilities(id int64) ([]Vulnerability, error)
+
+	getKnownHosts() (map[string]Host, error)
+	addOrUpdateHost(h SSHHost) error
+	setLastSeen(h SSHHost) error
+	setHostNames(h SSHHost) error
+	setHostKeys(h SSHHost) error
+	setHostAlgorithms(h SSHHost) error
+	setHostAuthMethods(h SSHHost) error
+	setHostKeyMismatch(runID int64, hostport, presented string) error
+	setHostRateLimit(hostport string, limit int) error
+	setHostProbed(hostport string, codePrompt bool) error
+	getHostProbes() (map[string]Host, error)
+	addHostFinding(f HostFinding) error
+	clearHostFinding(hostport, findingType string) error
+	addHostChanges(runID int64, new SSHHost, old Host) error
+	initHostCreds() (int, error)
+	getScanQueue() ([]ScanRequest, error)
+	getScanQueueSize() (int, error)
+	getRescanQueue() ([]ScanRequest, error)
+	updateBruteResult(runID int64, br BruteForceResult) error
+	setCredsSkipped(hostport string, creds []Credential) error
+	setCredDeferred(br BruteForceResult) error
+	addScanRun(run *ScanRun) error
+	finishScanRun(run ScanRun) error
+	interruptScanRun(run ScanRun, remaining []ScanRequest) error
+	failScanRun(run ScanRun, runErr error) error
+	getCheckpoint(runType string) (ScanRun, []ScanRequest, error)
+	clearCheckpoints(runType string) error
+}
+
+//NewStore opens the store referenced by uri.  postgres:// and postgresql://
+//URIs select the PostgreSQL backend, anything else is treated as a path to
+//a SQLite database, optionally prefixed with sqlite://
+func NewStore(uri string) (Store, error) {
+	if strings.HasPrefix(uri, "postgres://") || strings.HasPrefix(uri, "postgresql://") {
+		return NewPostgresStore(uri)
+	}
+	return NewSQLiteStore(strings.TrimPrefix(uri, "sqlite://"))
+}
+
+//timestampFormat is the layout of every timestamp stored in the database
+const timestampFormat = "2006-01-02 15:04:05"
+
+//dialect holds the bits of SQL that differ between database backends.
+//Timestamps are stored as 'YYYY-MM-DD HH:MM:SS' strings in local time on
+//every backend so they compare and display the same way.
+type dialect struct {
+	driver string
+	//migrations builds the schema, see migrate.go
+	migrations []migration
+	//lockSchema, if set, is run before migrating to keep concurrent
+	//instances from migrating the same database at once
+	lockSchema string
+	//tableCount is a query counting the tables named $1
+	tableCount string
+	//now is an expression for the current local time
+	now string
+	//daysAgo returns an expression for the local time the given number of
+	//days ago.  days is itself a SQL expression, like a column or parameter
+	daysAgo func(days string) string
+	//returningID is set if the id of a new row must be fetched using
+	//INSERT ... RETURNING id instead of sql.Result.LastInsertId
+	returningID bool
+}
+
+//sqlStore implements Store on top of any database/sql driver, using a
+//dialect for the backend specific parts
+type sqlStore struct {
 	conn    *sqlx.DB
 	tx      *sqlx.Tx
 	txDepth int
+	dialect dialect
+
+	//encrypted is set if passwords in the database are encrypted, crypter
+	//is only available once the store has been unlocked
+	encrypted bool
+	crypter   *secretCrypter
 }
 
-func NewSQLiteStore(uri string) (*SQLiteStore, error) {
-	conn, err := sqlx.Open("sqlite3", uri)
+func newSQLStore(d dialect, uri string) (*sqlStore, error) {
+	conn, err := sqlx.Open(d.driver, uri)
 	if err != nil {
 		return nil, err
 	}
-	return &SQLiteStore{conn: conn}, nil
+	return &sqlStore{conn: conn, dialect: d}, nil
 }
 
-func (s *SQLiteStore) Close() error {
+func (s *sqlStore) Close() error {
 	return s.conn.Close()
 }
 
-func (s *SQLiteStore) Init() error {
-	_, err := s.conn.Exec(schema)
+//Init creates or migrates the schema.  It fails with ErrSchemaTooNew if the
+//database has been migrated by a newer version of ssh-auditor.
+func (s *sqlStore) Init() error {
+	err := s.migrate()
+	if err != nil {
+		return errors.Wrap(err, "Init() failed")
+	}
+	s.encrypted, err = s.IsEncrypted()
 	return errors.Wrap(err, "Init() failed")
 }
 
-func (s *SQLiteStore) Begin() (*sqlx.Tx, error) {
+func (s *sqlStore) Begin() (*sqlx.Tx, error) {
 	if s.tx != nil {
 		s.txDepth += 1
 		//log.Printf("Returning existing transaction: depth=%d\n", s.txDepth)
@@ -128,7 +411,7 @@ func (s *SQLiteStore) Begin() (*sqlx.Tx, error) {
 	return s.tx, nil
 }
 
-func (s *SQLiteStore) Commit() error {
+func (s *sqlStore) Commit() error {
 	if s.tx == nil {
 		return errors.New("Commit outside of transaction")
 	}
@@ -143,7 +426,18 @@ func (s *SQLiteStore) Commit() error {
 	return err
 }
 
-func (s *SQLiteStore) Exec(query string, args ...interface{}) (sql.Result, error) {
+//rollback aborts the current transaction, including any enclosing ones
+func (s *sqlStore) rollback() error {
+	if s.tx == nil {
+		return errors.New("Rollback outside of transaction")
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	s.txDepth = 0
+	return err
+}
+
+func (s *sqlStore) Exec(query string, args ...interface{}) (sql.Result, error) {
 	tx, err := s.Begin()
 	defer s.Commit()
 	if err != nil {
@@ -151,7 +445,22 @@ func (s *SQLiteStore) Exec(query string, args ...interface{}) (sql.Result, error
 	}
 	return tx.Exec(query, args...)
 }
-func (s *SQLiteStore) Select(dest interface{}, query string, args ...interface{}) error {
+
+//insertReturningID runs an INSERT query and returns the id of the new row
+func (s *sqlStore) insertReturningID(query string, args ...interface{}) (int64, error) {
+	var id int64
+	if s.dialect.returningID {
+		err := s.Get(&id, query+" RETURNING id", args...)
+		return id, err
+	}
+	res, err := s.Exec(query, args...)
+	if err != nil {
+		return id, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *sqlStore) Select(dest interface{}, query string, args ...interface{}) error {
 	tx, err := s.Begin()
 	defer s.Commit()
 	if err != nil {
@@ -159,7 +468,7 @@ func (s *SQLiteStore) Select(dest interface{}, query string, args ...interface{}
 	}
 	return tx.Select(dest, query, args...)
 }
-func (s *SQLiteStore) Get(dest interface{}, query string, args ...interface{}) error {
+func (s *sqlStore) Get(dest interface{}, query string, args ...interface{}) error {
 	tx, err := s.Begin()
 	defer s.Commit()
 	if err != nil {
@@ -168,10 +477,18 @@ func (s *SQLiteStore) Get(dest interface{}, query string, args ...interface{}) e
 	return tx.Get(dest, query, args...)
 }
 
-func (s *SQLiteStore) AddCredential(c Credential) (bool, error) {
+func (s *sqlStore) AddCredential(c Credential) (bool, error) {
+	if _, err := c.MatchRules(); err != nil {
+		return false, errors.Wrap(err, "AddCredential")
+	}
+	password, err := s.encryptSecret(c.Password)
+	if err != nil {
+		return false, errors.Wrap(err, "AddCredential")
+	}
 	res, err := s.Exec(
-		"INSERT OR IGNORE INTO credentials (user, password, scan_interval) VALUES ($1, $2, $3)",
-		c.User, c.Password, c.ScanInterval)
+		`INSERT INTO credentials ("user", password, scan_interval) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING`,
+		c.User, password, c.ScanInterval)
 	if err != nil {
 		return false, errors.Wrap(err, "AddCredential")
 	}
@@ -181,13 +498,13 @@ func (s *SQLiteStore) AddCredential(c Credential) (bool, error) {
 	}
 	added := affected == 1
 	_, err = s.Exec(
-		"UPDATE credentials SET scan_interval=$1 WHERE user=$2 AND password=$3",
-		c.ScanInterval, c.User, c.Password)
+		`UPDATE credentials SET scan_interval=$1, rules=$2 WHERE "user"=$3 AND password=$4`,
+		c.ScanInterval, c.Rules, c.User, password)
 
 	return added, errors.Wrap(err, "AddCredential")
 }
 
-func (s *SQLiteStore) getKnownHosts() (map[string]Host, error) {
+func (s *sqlStore) getKnownHosts() (map[string]Host, error) {
 	hostList := []Host{}
 
 	hosts := make(map[string]Host)
@@ -196,24 +513,50 @@ func (s *SQLiteStore) getKnownHosts() (map[string]Host, error) {
 	if err != nil {
 		return hosts, errors.Wrap(err, "getKnownHosts")
 	}
+	err = s.attachHostKeys(hostList)
+	if err != nil {
+		return hosts, errors.Wrap(err, "getKnownHosts")
+	}
+	attachSoftware(hostList)
 	for _, h := range hostList {
 		hosts[h.Hostport] = h
 	}
 	return hosts, nil
 }
 
-func (s *SQLiteStore) resetHostCreds(h SSHHost) error {
+//attachHostKeys fills in the Keys of every host in hosts
+func (s *sqlStore) attachHostKeys(hosts []Host) error {
+	keyList := []HostKey{}
+	err := s.Select(&keyList, "SELECT * FROM host_keys ORDER BY hostport, type")
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]HostKey)
+	for _, k := range keyList {
+		keys[k.Hostport] = append(keys[k.Hostport], k)
+	}
+	for i := range hosts {
+		hosts[i].Keys = keys[hosts[i].Hostport]
+	}
+	return nil
+}
+
+func (s *sqlStore) resetHostCreds(h SSHHost) error {
 	_, err := s.Exec("UPDATE host_creds set last_tested=0 where hostport=$1", h.hostport)
 	return err
 }
 
-func (s *SQLiteStore) ResetInterval() error {
+func (s *sqlStore) ResetInterval() error {
 	_, err := s.Exec("UPDATE host_creds set last_tested=0")
 	return err
 }
 
-func (s *SQLiteStore) ResetCreds() error {
-	_, err := s.Exec("DELETE from host_creds")
+func (s *sqlStore) ResetCreds() error {
+	_, err := s.Exec("DELETE from scan_checkpoints")
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec("DELETE from host_creds")
 	if err != nil {
 		return err
 	}
@@ -221,15 +564,15 @@ func (s *SQLiteStore) ResetCreds() error {
 	return err
 }
 
-func (s *SQLiteStore) addOrUpdateHost(h SSHHost) error {
+func (s *sqlStore) addOrUpdateHost(h SSHHost) error {
 	err := s.resetHostCreds(h)
 	if err != nil {
 		return errors.Wrap(err, "addOrUpdateHost")
 	}
-	res, err := s.Exec(
-		`UPDATE hosts SET version=$1,fingerprint=$2,seen_last=datetime('now', 'localtime')
-			WHERE hostport=$3`,
-		h.version, h.keyfp, h.hostport)
+	res, err := s.Exec(fmt.Sprintf(
+		`UPDATE hosts SET version=$1,fingerprint=$2,seen_last=%s,names=$3,ptr_names=$4,probed=''
+			WHERE hostport=$5`, s.dialect.now),
+		h.version, h.keyfp, h.names, h.ptrNames, h.hostport)
 	if err != nil {
 		return err
 	}
@@ -237,48 +580,212 @@ func (s *SQLiteStore) addOrUpdateHost(h SSHHost) error {
 	if rows != 0 {
 		return errors.Wrap(err, "addOrUpdateHost")
 	}
-	_, err = s.Exec(
-		`INSERT INTO hosts (hostport, version, fingerprint, seen_first, seen_last) VALUES
-			($1, $2, $3, datetime('now', 'localtime'), datetime('now', 'localtime'))`,
-		h.hostport, h.version, h.keyfp)
+	_, err = s.Exec(fmt.Sprintf(
+		`INSERT INTO hosts (hostport, version, fingerprint, seen_first, seen_last, names, ptr_names) VALUES
+			($1, $2, $3, %s, %s, $4, $5)`, s.dialect.now, s.dialect.now),
+		h.hostport, h.version, h.keyfp, h.names, h.ptrNames)
 	return err
 }
 
-func (s *SQLiteStore) setLastSeen(h SSHHost) error {
+//setHostNames updates the forward and reverse names of a host without
+//otherwise treating it as changed
+func (s *sqlStore) setHostNames(h SSHHost) error {
+	_, err := s.Exec("UPDATE hosts SET names=$1, ptr_names=$2 WHERE hostport=$3",
+		h.names, h.ptrNames, h.hostport)
+	return errors.Wrap(err, "setHostNames")
+}
+
+//setHostKeys records the keys discovery found for a host, replacing any
+//previous key of the same type, and sets its fingerprint.  This accepts any
+//key a scan was refused, so the host is scanned again.
+func (s *sqlStore) setHostKeys(h SSHHost) error {
+	_, err := s.Exec("UPDATE hosts SET fingerprint=$1, key_mismatch='' WHERE hostport=$2", h.keyfp, h.hostport)
+	if err != nil {
+		return errors.Wrap(err, "setHostKeys")
+	}
+	q := fmt.Sprintf(`INSERT INTO host_keys (hostport, type, fingerprint, bits, seen_first, seen_last) VALUES
+			($1, $2, $3, $4, %[1]s, %[1]s)
+		ON CONFLICT (hostport, type) DO UPDATE SET
+			seen_first=CASE WHEN host_keys.fingerprint=excluded.fingerprint THEN host_keys.seen_first ELSE excluded.seen_first END,
+			fingerprint=excluded.fingerprint, bits=excluded.bits, seen_last=excluded.seen_last`,
+		s.dialect.now)
+	for _, k := range h.keys {
+		_, err = s.Exec(q, h.hostport, k.Type, k.Fingerprint, k.Bits)
+		if err != nil {
+			return errors.Wrap(err, "setHostKeys")
+		}
+	}
+	return nil
+}
+
+//setHostAlgorithms records the algorithms a host offers
+func (s *sqlStore) setHostAlgorithms(h SSHHost) error {
+	_, err := s.Exec(`UPDATE hosts SET kex_algorithms=$1, host_key_algorithms=$2, ciphers=$3, macs=$4
+		WHERE hostport=$5`,
+		strings.Join(h.algos.Kex, ","), strings.Join(h.algos.HostKey, ","),
+		strings.Join(h.algos.Ciphers, ","), strings.Join(h.algos.MACs, ","), h.hostport)
+	return errors.Wrap(err, "setHostAlgorithms")
+}
+
+//setHostKeyMismatch records a scan being presented a host key that wasn't
+//pinned, which keeps the host out of the scan queues
+func (s *sqlStore) setHostKeyMismatch(runID int64, hostport, presented string) error {
+	q := fmt.Sprintf(`INSERT INTO host_changes (time, hostport, type, old, new, scan_run_id)
+			SELECT %[1]s, hostport, 'key mismatch', fingerprint, $1, $2 FROM hosts WHERE hostport=$3`,
+		s.dialect.now)
+	_, err := s.Exec(q, presented, runID, hostport)
+	if err != nil {
+		return errors.Wrap(err, "setHostKeyMismatch")
+	}
+	_, err = s.Exec(fmt.Sprintf("UPDATE hosts SET key_mismatch=%s WHERE hostport=$1", s.dialect.now), hostport)
+	return errors.Wrap(err, "setHostKeyMismatch")
+}
+
+//setHostRateLimit sets how many connections hostport is expected to allow
+//before it starts blocking them
+func (s *sqlStore) setHostRateLimit(hostport string, limit int) error {
+	_, err := s.Exec(`UPDATE hosts SET rate_limit=$1 WHERE hostport=$2`, limit, hostport)
+	return errors.Wrap(err, "setHostRateLimit")
+}
+
+//setHostProbed records that hostport was checked for accepting any
+//credential, and whether the random password got a second factor prompt
+func (s *sqlStore) setHostProbed(hostport string, codePrompt bool) error {
+	prompt := 0
+	if codePrompt {
+		prompt = 1
+	}
+	_, err := s.Exec(fmt.Sprintf("UPDATE hosts SET probed=%s, probe_code_prompt=$1 WHERE hostport=$2", s.dialect.now),
+		prompt, hostport)
+	return errors.Wrap(err, "setHostProbed")
+}
+
+//getHostProbes returns the last probe of each host.  Probed is empty for
+//the hosts to probe before a scan, those never probed since they were
+//discovered or their keys changed, and those that accepted anything the
+//last time.
+func (s *sqlStore) getHostProbes() (map[string]Host, error) {
+	hosts := []Host{}
+	err := s.Select(&hosts, `SELECT hostport, probe_code_prompt,
+			CASE WHEN hostport IN (SELECT hostport FROM host_findings WHERE type=$1) THEN '' ELSE probed END probed
+		FROM hosts`, FindingAcceptsAnything)
+	if err != nil {
+		return nil, errors.Wrap(err, "getHostProbes")
+	}
+	probes := make(map[string]Host)
+	for _, h := range hosts {
+		probes[h.Hostport] = h
+	}
+	return probes, nil
+}
+
+//ClearHostRateLimit lets scans make as many connections to hostport as
+//they need again
+func (s *sqlStore) ClearHostRateLimit(hostport string) error {
+	_, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "ClearHostRateLimit")
+	}
+	err = s.setHostRateLimit(hostport, 0)
+	if err == nil {
+		err = s.clearHostFinding(hostport, FindingRateLimited)
+	}
+	if err != nil {
+		s.rollback()
+		return errors.Wrap(err, "ClearHostRateLimit")
+	}
+	return errors.Wrap(s.Commit(), "ClearHostRateLimit")
+}
+
+//setHostAuthMethods records the authentication methods a host advertises
+func (s *sqlStore) setHostAuthMethods(h SSHHost) error {
+	_, err := s.Exec(`UPDATE hosts SET auth_methods=$1 WHERE hostport=$2`,
+		strings.Join(h.authMethods, ","), h.hostport)
+	return errors.Wrap(err, "setHostAuthMethods")
+}
+
+//addHostFinding records a finding, keeping when it was first found if the
+//host already had a finding of the same type
+func (s *sqlStore) addHostFinding(f HostFinding) error {
+	q := fmt.Sprintf(`INSERT INTO host_findings (hostport, type, detail, first_found, last_found) VALUES
+			($1, $2, $3, %[1]s, %[1]s)
+		ON CONFLICT (hostport, type) DO UPDATE SET detail=excluded.detail, last_found=excluded.last_found`,
+		s.dialect.now)
+	_, err := s.Exec(q, f.Hostport, f.Type, f.Detail)
+	return errors.Wrap(err, "addHostFinding")
+}
+
+//clearHostFinding removes a finding a host no longer has
+func (s *sqlStore) clearHostFinding(hostport, findingType string) error {
+	_, err := s.Exec("DELETE FROM host_findings WHERE hostport=$1 AND type=$2", hostport, findingType)
+	return errors.Wrap(err, "clearHostFinding")
+}
+
+func (s *sqlStore) setLastSeen(h SSHHost) error {
 	_, err := s.Exec(
-		"UPDATE hosts SET seen_last=datetime('now', 'localtime') WHERE hostport=$1",
+		fmt.Sprintf("UPDATE hosts SET seen_last=%s WHERE hostport=$1", s.dialect.now),
 		h.hostport)
 	return errors.Wrap(err, "setLastSeen")
 }
 
-func (s *SQLiteStore) addHostChange(h SSHHost, changeType, old, new string) error {
-	q := `INSERT INTO host_changes (time, hostport, type, old, new) VALUES
-			(datetime('now', 'localtime'), $1, $2, $3, $4)`
-	_, err := s.Exec(q, h.hostport, changeType, old, new)
+func (s *sqlStore) addHostChange(runID int64, h SSHHost, changeType, old, new string) error {
+	q := fmt.Sprintf(`INSERT INTO host_changes (time, hostport, type, old, new, scan_run_id) VALUES
+			(%s, $1, $2, $3, $4, $5)`, s.dialect.now)
+	_, err := s.Exec(q, h.hostport, changeType, old, new, runID)
 	return errors.Wrap(err, "addHostChanges failed")
 }
 
-func (s *SQLiteStore) addHostChanges(new SSHHost, old Host) error {
+func (s *sqlStore) addHostChanges(runID int64, new SSHHost, old Host) error {
 	var err error
-	if old.Fingerprint != new.keyfp {
-		err = s.addHostChange(new, "fingerprint", old.Fingerprint, new.keyfp)
+	if len(old.Keys) == 0 || len(new.keys) == 0 {
+		//Hosts discovered before keys were collected per type only have
+		//a single fingerprint to compare
+		if old.Fingerprint != new.keyfp {
+			err = s.addHostChange(runID, new, "fingerprint", old.Fingerprint, new.keyfp)
+			if err != nil {
+				return errors.Wrap(err, "addHostChange")
+			}
+		}
+	}
+	oldKeys := make(map[string]string)
+	for _, k := range old.Keys {
+		oldKeys[k.Type] = k.Fingerprint
+	}
+	for _, k := range new.keys {
+		oldfp, known := oldKeys[k.Type]
+		switch {
+		case len(old.Keys) == 0:
+		case !known:
+			err = s.addHostChange(runID, new, "new key", "", k.Type+" "+k.Fingerprint)
+		case oldfp != k.Fingerprint:
+			err = s.addHostChange(runID, new, "fingerprint", k.Type+" "+oldfp, k.Type+" "+k.Fingerprint)
+		}
 		if err != nil {
 			return errors.Wrap(err, "addHostChange")
 		}
 	}
 	if old.Version != new.version {
-		err = s.addHostChange(new, "version", old.Version, new.version)
+		err = s.addHostChange(runID, new, "version", old.Version, new.version)
 	}
 	return errors.Wrap(err, "addHostChange")
 }
 
-func (s *SQLiteStore) GetAllCreds() ([]Credential, error) {
+func (s *sqlStore) GetAllCreds() ([]Credential, error) {
 	credentials := []Credential{}
-	err := s.Select(&credentials, "SELECT user, password, scan_interval from credentials")
-	return credentials, errors.Wrap(err, "getAllCreds")
+	err := s.Select(&credentials, `SELECT "user", password, scan_interval, rules from credentials`)
+	if err != nil {
+		return credentials, errors.Wrap(err, "getAllCreds")
+	}
+	for i := range credentials {
+		credentials[i].Password, err = s.decryptSecret(credentials[i].Password)
+		if err != nil {
+			return credentials, errors.Wrap(err, "getAllCreds")
+		}
+	}
+	return credentials, nil
 }
 
-func (s *SQLiteStore) initHostCreds() (int, error) {
+func (s *sqlStore) initHostCreds() (int, error) {
 	_, err := s.Begin()
 	defer s.Commit()
 	if err != nil {
@@ -294,9 +801,21 @@ func (s *SQLiteStore) initHostCreds() (int, error) {
 		return 0, errors.Wrap(err, "initHostCreds")
 	}
 
+	matchers := make([]*credentialMatcher, len(creds))
+	for i, c := range creds {
+		rules, err := c.MatchRules()
+		if err != nil {
+			return 0, errors.Wrap(err, "initHostCreds")
+		}
+		matchers[i], err = newCredentialMatcher(rules)
+		if err != nil {
+			return 0, errors.Wrap(err, "initHostCreds")
+		}
+	}
+
 	inserted := 0
 	for _, host := range knownHosts {
-		ins, err := s.initHostCredsForHost(creds, host)
+		ins, err := s.initHostCredsForHost(creds, matchers, host)
 		if err != nil {
 			return inserted, errors.Wrap(err, "initHostCreds")
 		}
@@ -304,12 +823,27 @@ func (s *SQLiteStore) initHostCreds() (int, error) {
 	}
 	return inserted, nil
 }
-func (s *SQLiteStore) initHostCredsForHost(creds []Credential, h Host) (int, error) {
+//initHostCredsForHost queues the credentials whose rules match h.  Queued
+//credentials that no longer match are dropped unless they ever worked, so
+//that fixing them is still noticed.
+func (s *sqlStore) initHostCredsForHost(creds []Credential, matchers []*credentialMatcher, h Host) (int, error) {
 	inserted := 0
-	for _, c := range creds {
-		res, err := s.Exec(`INSERT OR IGNORE INTO host_creds (hostport, user, password, last_tested, result, scan_interval) VALUES
-			($1, $2, $3, 0, '', $4)`,
-			h.Hostport, c.User, c.Password, c.ScanInterval)
+	for i, c := range creds {
+		password, err := s.encryptSecret(c.Password)
+		if err != nil {
+			return inserted, errors.Wrap(err, "initHostCredsForHost")
+		}
+		if !matchers[i].matches(h) {
+			_, err = s.Exec(`DELETE FROM host_creds WHERE hostport=$1 AND "user"=$2 AND password=$3 AND state=''`,
+				h.Hostport, c.User, password)
+			if err != nil {
+				return inserted, errors.Wrap(err, "initHostCredsForHost")
+			}
+			continue
+		}
+		res, err := s.Exec(`INSERT INTO host_creds (hostport, "user", password, last_tested, result, scan_interval) VALUES
+			($1, $2, $3, 0, '', $4) ON CONFLICT DO NOTHING`,
+			h.Hostport, c.User, password, c.ScanInterval)
 		if err != nil {
 			return inserted, errors.Wrap(err, "initHostCredsForHost")
 		}
@@ -319,16 +853,25 @@ func (s *SQLiteStore) initHostCredsForHost(creds []Credential, h Host) (int, err
 	return inserted, nil
 }
 
-func (s *SQLiteStore) getScanQueueHelper(query string) ([]ScanRequest, error) {
+//getScanQueueHelper returns the credentials selected by query with args
+//grouped by host, leaving out those allow returns false for if allow is set
+func (s *sqlStore) getScanQueueHelper(query string, allow func(hc HostCredential) bool, args ...interface{}) ([]ScanRequest, error) {
 	requestMap := make(map[string]*ScanRequest)
 	var requests []ScanRequest
 	credentials := []HostCredential{}
-	err := s.Select(&credentials, query)
+	err := s.Select(&credentials, query, args...)
 	if err != nil {
 		return requests, errors.Wrap(err, "getScanQueueHelper")
 	}
 
 	for _, hc := range credentials {
+		hc.Password, err = s.decryptSecret(hc.Password)
+		if err != nil {
+			return requests, errors.Wrap(err, "getScanQueueHelper")
+		}
+		if allow != nil && !allow(hc) {
+			continue
+		}
 		sr := requestMap[hc.Hostport]
 		if sr == nil {
 			sr = &ScanRequest{
@@ -339,83 +882,364 @@ func (s *SQLiteStore) getScanQueueHelper(query string) ([]ScanRequest, error) {
 		requestMap[hc.Hostport] = sr
 	}
 
+	pinned, err := s.pinnedHostKeys()
+	if err != nil {
+		return requests, errors.Wrap(err, "getScanQueueHelper")
+	}
+	limited := []Host{}
+	err = s.Select(&limited, `SELECT hostport, rate_limit FROM hosts WHERE rate_limit > 0`)
+	if err != nil {
+		return requests, errors.Wrap(err, "getScanQueueHelper")
+	}
+	rateLimits := make(map[string]int)
+	for _, h := range limited {
+		rateLimits[h.Hostport] = h.RateLimit
+	}
 	for _, sr := range requestMap {
+		sr.hostKeys = pinned[sr.hostport]
+		if limit, ok := rateLimits[sr.hostport]; ok {
+			sr.maxConnections = pacedConnections(limit)
+		}
 		requests = append(requests, *sr)
 	}
 
 	return requests, nil
 }
-func (s *SQLiteStore) getScanQueue() ([]ScanRequest, error) {
-	q := `select host_creds.* from host_creds, hosts
-		where hosts.hostport = host_creds.hostport and
-		last_tested < datetime('now', 'localtime',  -scan_interval || ' day') and
-		hosts.fingerprint != '' and
-		seen_last > datetime('now', 'localtime', '-7 day') order by last_tested ASC`
-	return s.getScanQueueHelper(q)
+
+//pinnedHostKeys returns the fingerprints of every key discovery found for
+//each host
+func (s *sqlStore) pinnedHostKeys() (map[string][]string, error) {
+	pinned := make(map[string][]string)
+	keys := []HostKey{}
+	err := s.Select(&keys, `SELECT hostport, fingerprint FROM host_keys`)
+	if err != nil {
+		return pinned, err
+	}
+	for _, k := range keys {
+		pinned[k.Hostport] = append(pinned[k.Hostport], k.Fingerprint)
+	}
+	//Hosts discovered before keys were collected per type only have a
+	//single fingerprint
+	hosts := []Host{}
+	err = s.Select(&hosts, `SELECT hostport, fingerprint FROM hosts WHERE fingerprint != ''`)
+	if err != nil {
+		return pinned, err
+	}
+	for _, h := range hosts {
+		if !containsAny(pinned[h.Hostport], []string{h.Fingerprint}) {
+			pinned[h.Hostport] = append(pinned[h.Hostport], h.Fingerprint)
+		}
+	}
+	return pinned, nil
 }
-func (s *SQLiteStore) getScanQueueSize() (int, error) {
-	q := `select count(*) from host_creds, hosts
+func (s *sqlStore) getScanQueue() ([]ScanRequest, error) {
+	q := fmt.Sprintf(`select host_creds.* from host_creds, hosts
 		where hosts.hostport = host_creds.hostport and
-		last_tested < datetime('now', 'localtime', -scan_interval || ' day') and
+		last_tested < %s and
 		hosts.fingerprint != '' and
-		seen_last > datetime('now', 'localtime', '-7 day')`
-
-	var cnt int
-	err := s.Get(&cnt, q)
-	return cnt, errors.Wrap(err, "getScanQueueSize")
+		hosts.key_mismatch = '' and
+		seen_last > %s order by last_tested ASC`,
+		s.dialect.daysAgo("scan_interval"), s.dialect.daysAgo("7"))
+	//Credentials of a type the host doesn't accept stay queued until it does
+	hosts := []Host{}
+	err := s.Select(&hosts, `select hostport, auth_methods from hosts where auth_methods != ''`)
+	if err != nil {
+		return nil, errors.Wrap(err, "getScanQueue")
+	}
+	methods := make(map[string][]string)
+	for _, h := range hosts {
+		methods[h.Hostport] = h.SupportedAuthMethods()
+	}
+	return s.getScanQueueHelper(q, func(hc HostCredential) bool {
+		return authMethodsAllow(methods[hc.Hostport], hc.Password)
+	})
 }
-func (s *SQLiteStore) getRescanQueue() ([]ScanRequest, error) {
-	q := `select * from host_creds where result !='' order by last_tested ASC`
-	return s.getScanQueueHelper(q)
+//getScanQueueSize returns how many credentials getScanQueue would return.
+//It uses the queue itself, so credentials the host's auth methods rule out
+//aren't counted.
+func (s *sqlStore) getScanQueueSize() (int, error) {
+	queue, err := s.getScanQueue()
+	if err != nil {
+		return 0, errors.Wrap(err, "getScanQueueSize")
+	}
+	cnt := 0
+	for _, sr := range queue {
+		cnt += len(sr.credentials)
+	}
+	return cnt, nil
+}
+func (s *sqlStore) getRescanQueue() ([]ScanRequest, error) {
+	q := `select host_creds.* from host_creds, hosts
+		where hosts.hostport = host_creds.hostport and
+		result != '' and
+		hosts.key_mismatch = '' order by last_tested ASC`
+	return s.getScanQueueHelper(q, nil)
 }
 
-func (s *SQLiteStore) updateBruteResult(br BruteForceResult) error {
+func (s *sqlStore) updateBruteResult(runID int64, br BruteForceResult) error {
 	if br.err != nil {
 		//If this BruteForceResult was an error.. as in, not a positive or
-		//negative result, don't update anything.  We can't say definitively
-		//that the credential does or does not work.
-		return nil
+		//negative result, only the error is recorded.  We can't say
+		//definitively that the credential does or does not work.
+		return s.updateBruteError(br)
+	}
+	var q string
+	if br.result != "" {
+		q = fmt.Sprintf(`UPDATE host_creds set last_tested=%[1]s, result=$1, scan_run_id=$2,
+			confirmed_result=$1,
+			state=CASE WHEN state='%[2]s' THEN '%[3]s' WHEN state='' THEN '%[4]s' ELSE state END,
+			first_detected=CASE WHEN first_detected='' THEN %[1]s ELSE first_detected END,
+			last_confirmed=%[1]s,
+			remediated_at='',
+			prompts=CASE WHEN $3 != '' THEN $3 ELSE prompts END,
+			error_type='', last_error='', last_error_at='', error_count=0,
+			deferred='', deferred_at=''
+			WHERE hostport=$4 AND "user"=$5 AND password=$6`,
+			s.dialect.now, VulnFixed, VulnReopened, VulnOpen)
+	} else {
+		//$1 is always empty here, but keeps the parameters in line with
+		//the positive case
+		q = fmt.Sprintf(`UPDATE host_creds set last_tested=%[1]s, result=$1, scan_run_id=$2,
+			state=CASE WHEN state IN ('%[2]s', '%[3]s') THEN '%[4]s' ELSE state END,
+			remediated_at=CASE WHEN state IN ('%[2]s', '%[3]s') THEN %[1]s ELSE remediated_at END,
+			prompts=CASE WHEN $3 != '' THEN $3 ELSE prompts END,
+			error_type='', last_error='', last_error_at='', error_count=0,
+			deferred='', deferred_at=''
+			WHERE hostport=$4 AND "user"=$5 AND password=$6`,
+			s.dialect.now, VulnOpen, VulnReopened, VulnFixed)
 	}
-	_, err := s.Exec(`UPDATE host_creds set last_tested=datetime('now', 'localtime'), result=$1
-		WHERE hostport=$2 AND user=$3 AND password=$4`,
-		br.result, br.hostport, br.cred.User, br.cred.Password)
+	password, err := s.encryptSecret(br.cred.Password)
+	if err != nil {
+		return errors.Wrap(err, "updateBruteResult")
+	}
+	//SQLite numbers parameters in order of appearance, so they must be
+	//passed in that order
+	_, err = s.Exec(q, br.result, runID, strings.Join(br.prompts, "\n"), br.hostport, br.cred.User, password)
 	if err != nil {
 		return errors.Wrap(err, "updateBruteResult")
 	}
 	//Also update the seen_last field on the hosts table, since a non-err
 	//BruteForceResult means the system was reachable.
 	_, err = s.Exec(
-		"UPDATE hosts SET seen_last=datetime('now', 'localtime') WHERE hostport=$1",
+		fmt.Sprintf("UPDATE hosts SET seen_last=%s WHERE hostport=$1", s.dialect.now),
 		br.hostport)
 	return errors.Wrap(err, "updateBruteResult")
 }
 
-func (s *SQLiteStore) GetVulnerabilities() ([]Vulnerability, error) {
+func (s *sqlStore) updateBruteError(br BruteForceResult) error {
+	password, err := s.encryptSecret(br.cred.Password)
+	if err != nil {
+		return errors.Wrap(err, "updateBruteError")
+	}
+	_, err = s.Exec(fmt.Sprintf(`UPDATE host_creds set error_type=$1, last_error=$2, last_error_at=%s,
+		error_count=error_count+1, deferred='', deferred_at=''
+		WHERE hostport=$3 AND "user"=$4 AND password=$5`, s.dialect.now),
+		ConnectionErrorType(br.err), connectionErrorDetail(br.err), br.hostport, br.cred.User, password)
+	return errors.Wrap(err, "updateBruteError")
+}
+
+//setCredDeferred records why the credential of br wasn't tried
+func (s *sqlStore) setCredDeferred(br BruteForceResult) error {
+	password, err := s.encryptSecret(br.cred.Password)
+	if err != nil {
+		return errors.Wrap(err, "setCredDeferred")
+	}
+	_, err = s.Exec(fmt.Sprintf(`UPDATE host_creds set deferred=$1, deferred_at=%s
+		WHERE hostport=$2 AND "user"=$3 AND password=$4`, s.dialect.now),
+		br.deferred, br.hostport, br.cred.User, password)
+	return errors.Wrap(err, "setCredDeferred")
+}
+
+//setCredsSkipped marks credentials of a host as tested without touching
+//their results, so they leave the scan queue until their interval is up
+func (s *sqlStore) setCredsSkipped(hostport string, creds []Credential) error {
+	q := fmt.Sprintf(`UPDATE host_creds set last_tested=%s
+		WHERE hostport=$1 AND "user"=$2 AND password=$3`, s.dialect.now)
+	for _, c := range creds {
+		password, err := s.encryptSecret(c.Password)
+		if err != nil {
+			return errors.Wrap(err, "setCredsSkipped")
+		}
+		_, err = s.Exec(q, hostport, c.User, password)
+		if err != nil {
+			return errors.Wrap(err, "setCredsSkipped")
+		}
+	}
+	return nil
+}
+
+//getVulnerabilitiesWhere returns the vulnerabilities matching an additional
+//where clause, which may reference host_creds as hc and hosts as h
+func (s *sqlStore) getVulnerabilitiesWhere(where string, args ...interface{}) ([]Vulnerability, error) {
 	creds := []Vulnerability{}
-	q := `select
-			hc.hostport, hc.user, hc.password, hc.result, hc.last_tested,
+	q := fmt.Sprintf(`select
+			hc.hostport, hc."user", hc.password, hc.result, hc.last_tested,
+			hc.state, hc.first_detected, hc.last_confirmed, hc.remediated_at, hc.confirmed_result, hc.prompts,
 			h.version "host.version", h.hostport "host.hostport",
-			h.seen_first "host.seen_first", h.seen_last "host.seen_last", h.fingerprint "host.fingerprint"
+			h.seen_first "host.seen_first", h.seen_last "host.seen_last", h.fingerprint "host.fingerprint",
+			h.names "host.names", h.ptr_names "host.ptr_names"
 		from
 			host_creds hc, hosts h
 		where
 			h.hostport = hc.hostport
-		and result!='' order by last_tested asc`
+		and %s order by last_tested asc`, where)
 
-	err := s.Select(&creds, q)
+	err := s.Select(&creds, q, args...)
+	if err != nil {
+		return creds, err
+	}
+	for i := range creds {
+		creds[i].Host.Software = ParseBanner(creds[i].Host.Version)
+		creds[i].HostCredential.Password, err = s.decryptSecret(creds[i].HostCredential.Password)
+		if err != nil {
+			return creds, err
+		}
+	}
+	return creds, nil
+}
+
+func (s *sqlStore) GetVulnerabilities() ([]Vulnerability, error) {
+	creds, err := s.getVulnerabilitiesWhere("result!=''")
 	return creds, errors.Wrap(err, "GetVulnerabilities")
 }
 
+//GetFixedVulnerabilities returns credentials that used to work but no
+//longer do
+func (s *sqlStore) GetFixedVulnerabilities() ([]Vulnerability, error) {
+	creds, err := s.getVulnerabilitiesWhere("state=$1", VulnFixed)
+	return creds, errors.Wrap(err, "GetFixedVulnerabilities")
+}
+
+//GetFindings returns every host finding
+func (s *sqlStore) GetFindings() ([]Finding, error) {
+	findings := []Finding{}
+	err := s.Select(&findings, `select
+			f.hostport, f.type, f.detail, f.first_found, f.last_found,
+			h.version "host.version", h.hostport "host.hostport",
+			h.seen_first "host.seen_first", h.seen_last "host.seen_last", h.fingerprint "host.fingerprint",
+			h.names "host.names", h.ptr_names "host.ptr_names"
+		from
+			host_findings f, hosts h
+		where
+			h.hostport = f.hostport
+		order by f.type, f.hostport`)
+	for i := range findings {
+		findings[i].Host.Software = ParseBanner(findings[i].Host.Version)
+	}
+	return findings, errors.Wrap(err, "GetFindings")
+}
+
+//GetHostErrors returns the hosts with credentials failing with connection
+//errors or deferred by the last run, and those with credentials none of
+//which were tested yet
+func (s *sqlStore) GetHostErrors() ([]HostErrors, error) {
+	var rows []struct {
+		Hostport      string
+		Version       string
+		ErrorType     string `db:"error_type"`
+		Credentials   int
+		Tested        int
+		MaxErrorCount int    `db:"max_error_count"`
+		LastError     string `db:"last_error"`
+		LastErrorAt   string `db:"last_error_at"`
+	}
+	//last_tested is set back to 0 when a credential is queued again.  The
+	//message is taken from the newest error of each type.
+	err := s.Select(&rows, `select
+			hc.hostport, h.version, hc.error_type, count(*) credentials,
+			sum(CASE WHEN hc.last_tested != '0' THEN 1 ELSE 0 END) tested,
+			max(hc.error_count) max_error_count, max(hc.last_error_at) last_error_at,
+			(select e.last_error from host_creds e
+				where e.hostport = hc.hostport and e.error_type = hc.error_type
+				order by e.last_error_at desc limit 1) last_error
+		from
+			host_creds hc, hosts h
+		where
+			h.hostport = hc.hostport
+		group by hc.hostport, h.version, hc.error_type
+		order by hc.hostport, hc.error_type`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetHostErrors")
+	}
+	var deferred []struct {
+		Hostport     string
+		Deferred     string
+		Credentials  int
+		LastDeferred string `db:"last_deferred"`
+	}
+	err = s.Select(&deferred, `select hostport, deferred, count(*) credentials, max(deferred_at) last_deferred
+		from host_creds where deferred != '' group by hostport, deferred`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetHostErrors")
+	}
+	var hosts []HostErrors
+	var lastErrorAt string
+	for _, r := range rows {
+		if len(hosts) == 0 || hosts[len(hosts)-1].Hostport != r.Hostport {
+			hosts = append(hosts, HostErrors{Hostport: r.Hostport, Version: r.Version})
+			lastErrorAt = ""
+		}
+		he := &hosts[len(hosts)-1]
+		he.Credentials += r.Credentials
+		he.Tested += r.Tested
+		if r.ErrorType == "" {
+			continue
+		}
+		if he.Errors == nil {
+			he.Errors = make(map[string]int)
+		}
+		he.Errors[r.ErrorType] = r.Credentials
+		he.Failing += r.Credentials
+		if r.MaxErrorCount > he.MaxErrorCount {
+			he.MaxErrorCount = r.MaxErrorCount
+		}
+		//Timestamps sort as strings in both dialects
+		if r.LastErrorAt >= lastErrorAt {
+			lastErrorAt = r.LastErrorAt
+			he.LastError = r.LastError
+		}
+	}
+	index := make(map[string]int)
+	for i, he := range hosts {
+		index[he.Hostport] = i
+	}
+	for _, d := range deferred {
+		i, ok := index[d.Hostport]
+		if !ok {
+			continue
+		}
+		he := &hosts[i]
+		if he.Deferred == nil {
+			he.Deferred = make(map[string]int)
+		}
+		he.Deferred[d.Deferred] = d.Credentials
+		if d.LastDeferred > he.LastDeferred {
+			he.LastDeferred = d.LastDeferred
+		}
+	}
+	hostErrors := []HostErrors{}
+	for _, he := range hosts {
+		if he.Failing != 0 || he.Tested == 0 || len(he.Deferred) != 0 {
+			hostErrors = append(hostErrors, he)
+		}
+	}
+	return hostErrors, nil
+}
+
 //GetActiveHosts returns a list of hosts seen at most maxAgeDays ago
-func (s *SQLiteStore) GetActiveHosts(maxAgeDays int) ([]Host, error) {
+func (s *sqlStore) GetActiveHosts(maxAgeDays int) ([]Host, error) {
 	hostList := []Host{}
-	dayInterval := fmt.Sprintf("-%d day", maxAgeDays)
-	query := `SELECT * FROM hosts WHERE seen_last >= datetime('now', 'localtime', $1)`
-	err := s.Select(&hostList, query, dayInterval)
+	query := fmt.Sprintf(`SELECT * FROM hosts WHERE seen_last >= %s`, s.dialect.daysAgo("$1"))
+	err := s.Select(&hostList, query, maxAgeDays)
+	if err != nil {
+		return hostList, errors.Wrap(err, "GetActiveHosts")
+	}
+	err = s.attachHostKeys(hostList)
+	attachSoftware(hostList)
 	return hostList, errors.Wrap(err, "GetActiveHosts")
 }
 
-func (s *SQLiteStore) DeleteHost(hostport string) error {
+func (s *sqlStore) DeleteHost(hostport string) error {
 	s.Begin()
 	defer s.Commit()
 	_, err := s.Exec("DELETE FROM hosts where hostport=$1", hostport)
@@ -423,5 +1247,155 @@ func (s *SQLiteStore) DeleteHost(hostport string) error {
 		return err
 	}
 	_, err = s.Exec("DELETE FROM host_creds where hostport=$1", hostport)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec("DELETE FROM host_keys where hostport=$1", hostport)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec("DELETE FROM host_findings where hostport=$1", hostport)
 	return err
 }
+
+//addScanRun records the start of a run and sets run.ID
+func (s *sqlStore) addScanRun(run *ScanRun) error {
+	q := fmt.Sprintf(`INSERT INTO scan_runs (type, started, finished, include, exclude, ports, concurrency, timeout_ms)
+		VALUES ($1, %s, '', $2, $3, $4, $5, $6)`, s.dialect.now)
+	id, err := s.insertReturningID(q,
+		run.Type, run.Include, run.Exclude, run.Ports, run.Concurrency, run.TimeoutMs)
+	if err != nil {
+		return errors.Wrap(err, "addScanRun")
+	}
+	run.ID = id
+	return nil
+}
+
+//finishScanRun records the end time and the counts of a run
+func (s *sqlStore) finishScanRun(run ScanRun) error {
+	q := fmt.Sprintf(`UPDATE scan_runs SET finished=%s, interrupted='', total_count=$1, neg_count=$2, pos_count=$3,
+		err_count=$4, new_count=$5, updated_count=$6, deferred_count=$7 WHERE id=$8`, s.dialect.now)
+	_, err := s.Exec(q,
+		run.TotalCount, run.NegCount, run.PosCount,
+		run.ErrCount, run.NewCount, run.UpdatedCount, run.DeferredCount, run.ID)
+	return errors.Wrap(err, "finishScanRun")
+}
+
+//failScanRun records the counts of a run that stopped with runErr.  A
+//transaction the run left open is rolled back first, so only what it
+//committed is kept.
+func (s *sqlStore) failScanRun(run ScanRun, runErr error) error {
+	if s.tx != nil {
+		s.rollback()
+	}
+	q := fmt.Sprintf(`UPDATE scan_runs SET finished=%s, failed=$1, total_count=$2, neg_count=$3, pos_count=$4,
+		err_count=$5, new_count=$6, updated_count=$7, deferred_count=$8 WHERE id=$9`, s.dialect.now)
+	_, err := s.Exec(q, runErr.Error(),
+		run.TotalCount, run.NegCount, run.PosCount,
+		run.ErrCount, run.NewCount, run.UpdatedCount, run.DeferredCount, run.ID)
+	return errors.Wrap(err, "failScanRun")
+}
+
+//interruptScanRun records the counts of a run that was canceled and replaces
+//its checkpoint with the credentials in remaining
+func (s *sqlStore) interruptScanRun(run ScanRun, remaining []ScanRequest) error {
+	_, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "interruptScanRun")
+	}
+	err = s.writeCheckpoint(run, remaining)
+	if err != nil {
+		s.rollback()
+		return errors.Wrap(err, "interruptScanRun")
+	}
+	return errors.Wrap(s.Commit(), "interruptScanRun")
+}
+
+//writeCheckpoint marks run as interrupted and replaces its checkpoint with
+//the remaining credentials
+func (s *sqlStore) writeCheckpoint(run ScanRun, remaining []ScanRequest) error {
+	q := fmt.Sprintf(`UPDATE scan_runs SET finished=%s, interrupted=%s, total_count=$1, neg_count=$2, pos_count=$3,
+		err_count=$4, new_count=$5, updated_count=$6, deferred_count=$7 WHERE id=$8`, s.dialect.now, s.dialect.now)
+	_, err := s.Exec(q,
+		run.TotalCount, run.NegCount, run.PosCount,
+		run.ErrCount, run.NewCount, run.UpdatedCount, run.DeferredCount, run.ID)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec("DELETE FROM scan_checkpoints WHERE scan_run_id=$1", run.ID)
+	if err != nil {
+		return err
+	}
+	for _, sr := range remaining {
+		for _, c := range sr.credentials {
+			password, err := s.encryptSecret(c.Password)
+			if err != nil {
+				return err
+			}
+			_, err = s.Exec(`INSERT INTO scan_checkpoints (scan_run_id, hostport, "user", password)
+				VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+				run.ID, sr.hostport, c.User, password)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//getCheckpoint returns the most recent interrupted run of runType and the
+//credentials it didn't get to, leaving out hosts that have since presented
+//the wrong host key.  The run's ID is 0 if there is nothing to resume.
+func (s *sqlStore) getCheckpoint(runType string) (ScanRun, []ScanRequest, error) {
+	var run ScanRun
+	runs := []ScanRun{}
+	err := s.Select(&runs, `SELECT * FROM scan_runs WHERE type=$1 AND interrupted != ''
+		AND id IN (SELECT scan_run_id FROM scan_checkpoints) ORDER BY id DESC LIMIT 1`, runType)
+	if err != nil || len(runs) == 0 {
+		return run, nil, errors.Wrap(err, "getCheckpoint")
+	}
+	run = runs[0]
+	q := `select host_creds.* from host_creds, hosts, scan_checkpoints c
+		where hosts.hostport = host_creds.hostport and
+		c.scan_run_id = $1 and
+		c.hostport = host_creds.hostport and
+		c."user" = host_creds."user" and
+		c.password = host_creds.password and
+		hosts.key_mismatch = '' order by last_tested ASC`
+	requests, err := s.getScanQueueHelper(q, nil, run.ID)
+	return run, requests, errors.Wrap(err, "getCheckpoint")
+}
+
+//clearCheckpoints forgets where interrupted runs of runType stopped
+func (s *sqlStore) clearCheckpoints(runType string) error {
+	_, err := s.Exec(`DELETE FROM scan_checkpoints WHERE scan_run_id IN
+		(SELECT id FROM scan_runs WHERE type=$1)`, runType)
+	return errors.Wrap(err, "clearCheckpoints")
+}
+
+//GetScanRuns returns the most recent runs, newest first
+func (s *sqlStore) GetScanRuns(limit int) ([]ScanRun, error) {
+	runs := []ScanRun{}
+	err := s.Select(&runs, "SELECT * FROM scan_runs ORDER BY id DESC LIMIT $1", limit)
+	return runs, errors.Wrap(err, "GetScanRuns")
+}
+
+func (s *sqlStore) GetScanRun(id int64) (ScanRun, error) {
+	var run ScanRun
+	err := s.Get(&run, "SELECT * FROM scan_runs WHERE id=$1", id)
+	return run, errors.Wrap(err, "GetScanRun")
+}
+
+//GetScanRunChanges returns the host changes detected by a discover run
+func (s *sqlStore) GetScanRunChanges(id int64) ([]HostChange, error) {
+	changes := []HostChange{}
+	err := s.Select(&changes, "SELECT * FROM host_changes WHERE scan_run_id=$1 ORDER BY time ASC", id)
+	return changes, errors.Wrap(err, "GetScanRunChanges")
+}
+
+//GetScanRunVulnerabilities returns the vulnerabilities that were most
+//recently confirmed by the given run
+func (s *sqlStore) GetScanRunVulnerabilities(id int64) ([]Vulnerability, error) {
+	creds, err := s.getVulnerabilitiesWhere("result!='' and hc.scan_run_id=$1", id)
+	return creds, errors.Wrap(err, "GetScanRunVulnerabilities")
+}
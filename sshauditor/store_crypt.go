@@ -0,0 +1,173 @@
+package sshauditor
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+//ErrNotEncrypted is returned when unlocking or rekeying a store whose
+//passwords are not encrypted
+var ErrNotEncrypted = errors.New("database is not encrypted")
+
+type encryptionSettings struct {
+	Salt     string
+	Verifier string
+}
+
+//encryptSecret returns the value to store for a password or private key
+func (s *sqlStore) encryptSecret(secret string) (string, error) {
+	if s.crypter != nil {
+		return s.crypter.encrypt(secret), nil
+	}
+	if s.encrypted {
+		return "", ErrPassphraseRequired
+	}
+	return secret, nil
+}
+
+//decryptSecret reverses encryptSecret.  Values in a store that is not
+//encrypted are returned as is, whatever they look like.
+func (s *sqlStore) decryptSecret(secret string) (string, error) {
+	if !s.encrypted {
+		return secret, nil
+	}
+	if s.crypter == nil {
+		return "", ErrPassphraseRequired
+	}
+	return s.crypter.decrypt(secret)
+}
+
+func (s *sqlStore) getEncryptionSettings() ([]encryptionSettings, error) {
+	settings := []encryptionSettings{}
+	err := s.Select(&settings, "SELECT salt, verifier FROM encryption")
+	return settings, err
+}
+
+//IsEncrypted returns true if passwords in the database are encrypted
+func (s *sqlStore) IsEncrypted() (bool, error) {
+	settings, err := s.getEncryptionSettings()
+	return len(settings) != 0, errors.Wrap(err, "IsEncrypted")
+}
+
+//Unlock derives the encryption key from passphrase so that encrypted
+//passwords can be read and written
+func (s *sqlStore) Unlock(passphrase []byte) error {
+	settings, err := s.getEncryptionSettings()
+	if err != nil {
+		return errors.Wrap(err, "Unlock")
+	}
+	if len(settings) == 0 {
+		return ErrNotEncrypted
+	}
+	c, err := newSecretCrypter(passphrase, settings[0].Salt)
+	if err != nil {
+		return errors.Wrap(err, "Unlock")
+	}
+	if c.encrypt(verifierPlaintext) != settings[0].Verifier {
+		return ErrWrongPassphrase
+	}
+	s.crypter = c
+	s.encrypted = true
+	return nil
+}
+
+//rewriteSecrets replaces every stored password with transform(password)
+func (s *sqlStore) rewriteSecrets(transform func(string) (string, error)) error {
+	for _, table := range []string{"credentials", "host_creds", "scan_checkpoints"} {
+		var passwords []string
+		err := s.Select(&passwords, fmt.Sprintf("SELECT DISTINCT password FROM %s", table))
+		if err != nil {
+			return err
+		}
+		for _, old := range passwords {
+			new, err := transform(old)
+			if err != nil {
+				return err
+			}
+			if new == old {
+				continue
+			}
+			_, err = s.Exec(fmt.Sprintf("UPDATE %s SET password=$1 WHERE password=$2", table), new, old)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//setEncryption derives a new key from passphrase, rewrites every password
+//with transform and stores the new salt, all in one transaction
+func (s *sqlStore) setEncryption(passphrase []byte, transform func(c *secretCrypter, secret string) (string, error)) error {
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	c, err := newSecretCrypter(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	_, err = s.Begin()
+	if err != nil {
+		return err
+	}
+	err = s.rewriteSecrets(func(secret string) (string, error) {
+		return transform(c, secret)
+	})
+	if err == nil {
+		_, err = s.Exec("DELETE FROM encryption")
+	}
+	if err == nil {
+		_, err = s.Exec("INSERT INTO encryption (salt, verifier) VALUES ($1, $2)",
+			salt, c.encrypt(verifierPlaintext))
+	}
+	if err != nil {
+		s.rollback()
+		return err
+	}
+	err = s.Commit()
+	if err != nil {
+		return err
+	}
+	s.crypter = c
+	s.encrypted = true
+	return nil
+}
+
+//Encrypt encrypts every stored password using a key derived from
+//passphrase.  The store is left unlocked.
+func (s *sqlStore) Encrypt(passphrase []byte) error {
+	encrypted, err := s.IsEncrypted()
+	if err != nil {
+		return errors.Wrap(err, "Encrypt")
+	}
+	if encrypted {
+		return errors.New("Encrypt: database is already encrypted, use rekey to change the passphrase")
+	}
+	//Nothing is encrypted yet, even passwords that look like they are
+	err = s.setEncryption(passphrase, func(c *secretCrypter, secret string) (string, error) {
+		return c.encrypt(secret), nil
+	})
+	return errors.Wrap(err, "Encrypt")
+}
+
+//Rekey re-encrypts every stored password with a key derived from
+//newPassphrase.  The store must already be unlocked.
+func (s *sqlStore) Rekey(newPassphrase []byte) error {
+	if !s.encrypted {
+		return ErrNotEncrypted
+	}
+	if s.crypter == nil {
+		return ErrPassphraseRequired
+	}
+	old := s.crypter
+	err := s.setEncryption(newPassphrase, func(c *secretCrypter, secret string) (string, error) {
+		plaintext, err := old.decrypt(secret)
+		if err != nil {
+			return "", err
+		}
+		return c.encrypt(plaintext), nil
+	})
+	return errors.Wrap(err, "Rekey")
+}
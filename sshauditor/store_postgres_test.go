@@ -0,0 +1,101 @@
+package sshauditor
+
+import (
+	"os"
+	"testing"
+)
+
+//newTestPostgresStore returns a freshly initialized PostgresStore using the
+//database referenced by SSH_AUDITOR_TEST_POSTGRES, or skips the test if it is
+//not set.  All tables are emptied so tests start from a known state.
+func newTestPostgresStore(t *testing.T) *PostgresStore {
+	uri := os.Getenv("SSH_AUDITOR_TEST_POSTGRES")
+	if uri == "" {
+		t.Skip("SSH_AUDITOR_TEST_POSTGRES not set")
+	}
+	s, err := NewPostgresStore(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Exec("TRUNCATE hosts, credentials, host_creds, host_changes, scan_runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostgresStore(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s := newTestPostgresStore(t)
+	defer s.Close()
+
+	_, err := s.Begin()
+	check(err)
+	cred := Credential{User: "foo", Password: "foo", ScanInterval: 5}
+	added, err := s.AddCredential(cred)
+	check(err)
+	if added != true {
+		t.Errorf("Expected added to be true")
+	}
+	added, err = s.AddCredential(cred)
+	check(err)
+	if added != false {
+		t.Errorf("Expected added to be false")
+	}
+	check(s.Commit())
+
+	check(s.addOrUpdateHost(SSHHost{
+		hostport: "192.168.1.1:22",
+		version:  "whatever",
+		keyfp:    "whatever",
+	}))
+	queued, err := s.initHostCreds()
+	check(err)
+	if queued != 1 {
+		t.Errorf("Expected 1 queued host_cred, got %d", queued)
+	}
+	size, err := s.getScanQueueSize()
+	check(err)
+	if size != 1 {
+		t.Errorf("Expected scan queue size of 1, got %d", size)
+	}
+
+	run := newScanRun("scan", ScanConfiguration{Concurrency: 1})
+	check(s.addScanRun(&run))
+	if run.ID == 0 {
+		t.Errorf("Expected addScanRun to set the run id")
+	}
+	check(s.updateBruteResult(run.ID, BruteForceResult{
+		hostport: "192.168.1.1:22",
+		cred:     cred,
+		result:   "exec",
+	}))
+	vulns, err := s.GetVulnerabilities()
+	check(err)
+	if len(vulns) != 1 {
+		t.Fatalf("Expected 1 vulnerability, got %d", len(vulns))
+	}
+	if vulns[0].Host.Hostport != "192.168.1.1:22" || vulns[0].HostCredential.Result != "exec" {
+		t.Errorf("Unexpected vulnerability %#v", vulns[0])
+	}
+	runVulns, err := s.GetScanRunVulnerabilities(run.ID)
+	check(err)
+	if len(runVulns) != 1 {
+		t.Errorf("Expected 1 vulnerability for run %d, got %d", run.ID, len(runVulns))
+	}
+
+	check(s.DeleteHost("192.168.1.1:22"))
+	hosts, err := s.GetActiveHosts(7)
+	check(err)
+	if len(hosts) != 0 {
+		t.Errorf("Expected 0 hosts, got %d", len(hosts))
+	}
+}
@@ -0,0 +1,139 @@
+package sshauditor
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteInitialSchema = `
+CREATE TABLE IF NOT EXISTS hosts (
+	hostport character varying,
+	version character varying,
+	fingerprint character varying,
+	seen_first REAL,
+	seen_last REAL,
+
+	PRIMARY KEY (hostport)
+);
+
+CREATE TABLE IF NOT EXISTS credentials (
+	user character varying,
+	password character varying,
+	scan_interval DEFAULT 14,
+
+	PRIMARY KEY (user, password)
+);
+
+CREATE TABLE IF NOT EXISTS host_creds (
+	hostport character varying,
+	user character varying,
+	password character varying,
+	last_tested REAL,
+	result character varying,
+	scan_interval DEFAULT 0,
+
+	PRIMARY KEY (hostport, user, password)
+);
+
+CREATE TABLE IF NOT EXISTS host_changes (
+	time REAL,
+	hostport character varying,
+	type character varying,
+	old character varying,
+	new character varying
+);
+
+CREATE INDEX IF NOT EXISTS host_creds_vulnerable ON host_creds (result) WHERE result != '';
+`
+
+//sqliteInitialSchemaMigration creates the schema used before versioned
+//migrations existed.  Databases created by early versions of ssh-auditor
+//already have these tables but call the scan_interval column priority, so
+//rename it where needed.
+func sqliteInitialSchemaMigration(tx *sqlx.Tx) error {
+	_, err := tx.Exec(sqliteInitialSchema)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"credentials", "host_creds"} {
+		var legacy int
+		err = tx.Get(&legacy, "SELECT count(*) FROM pragma_table_info($1) WHERE name='priority'", table)
+		if err != nil {
+			return err
+		}
+		if legacy == 0 {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN priority TO scan_interval", table))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var sqliteDialect = dialect{
+	driver: "sqlite3",
+	migrations: []migration{
+		{"initial schema", sqliteInitialSchemaMigration},
+		{"scan runs", execMigration(`
+			CREATE TABLE scan_runs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				type character varying,
+				started character varying,
+				finished character varying,
+				include character varying,
+				exclude character varying,
+				ports character varying,
+				concurrency integer,
+				timeout_ms integer,
+				total_count integer DEFAULT 0,
+				neg_count integer DEFAULT 0,
+				pos_count integer DEFAULT 0,
+				err_count integer DEFAULT 0,
+				new_count integer DEFAULT 0,
+				updated_count integer DEFAULT 0
+			);
+			ALTER TABLE host_creds ADD COLUMN scan_run_id integer;
+			ALTER TABLE host_changes ADD COLUMN scan_run_id integer;
+			CREATE INDEX host_changes_scan_run ON host_changes (scan_run_id);
+		`)},
+		vulnLifecycleMigration,
+		credentialEncryptionMigration,
+		hostNamesMigration,
+		hostKeysMigration,
+		hostAlgorithmsMigration,
+		hostFindingsMigration,
+		hostAuthMethodsMigration,
+		authPromptsMigration,
+		hostKeyMismatchMigration,
+		credentialRulesMigration,
+		scanCheckpointsMigration,
+		deferredCountMigration,
+		hostRateLimitMigration,
+		credentialErrorsMigration,
+		credentialErrorTimeMigration,
+		scanRunFailedMigration,
+		hostProbedMigration,
+		credentialDeferralMigration,
+	},
+	tableCount: "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = $1",
+	now:        "datetime('now', 'localtime')",
+	daysAgo: func(days string) string {
+		return fmt.Sprintf("datetime('now', 'localtime', '-' || CAST(%s AS integer) || ' day')", days)
+	},
+}
+
+type SQLiteStore struct {
+	*sqlStore
+}
+
+func NewSQLiteStore(uri string) (*SQLiteStore, error) {
+	s, err := newSQLStore(sqliteDialect, uri)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{s}, nil
+}
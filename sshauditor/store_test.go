@@ -2,6 +2,9 @@ package sshauditor
 
 import (
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 func TestAddCredential(t *testing.T) {
@@ -83,3 +86,376 @@ func TestAddAndDeleteHost(t *testing.T) {
 		t.Fatalf("Expected 0 hosts, got %d", len(knownHosts))
 	}
 }
+
+func TestNestedTransactions(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	err = s.Init()
+	check(err)
+
+	outer, err := s.Begin()
+	check(err)
+	inner, err := s.Begin()
+	check(err)
+	if inner != outer {
+		t.Errorf("Expected nested Begin to return the outer transaction")
+	}
+	_, err = s.AddCredential(Credential{User: "foo", Password: "foo", ScanInterval: 5})
+	check(err)
+	check(s.Commit())
+	if s.tx == nil {
+		t.Errorf("Expected inner Commit to leave the outer transaction open")
+	}
+	check(s.Commit())
+	if s.tx != nil {
+		t.Errorf("Expected outer Commit to close the transaction")
+	}
+	if err := s.Commit(); err == nil {
+		t.Errorf("Expected Commit outside of a transaction to fail")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	var testCases = []struct {
+		uri      string
+		postgres bool
+	}{
+		{"ssh_db.sqlite", false},
+		{"sqlite://:memory:", false},
+		{"postgres://localhost/ssh_auditor", true},
+		{"postgresql://localhost/ssh_auditor", true},
+	}
+	for _, tt := range testCases {
+		s, err := NewStore(tt.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isPostgres := s.(*PostgresStore)
+		if isPostgres != tt.postgres {
+			t.Errorf("NewStore(%q) => %T", tt.uri, s)
+		}
+		s.Close()
+	}
+}
+
+func TestScanRuns(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	err = s.Init()
+	check(err)
+
+	host := SSHHost{hostport: "192.168.1.1:22", version: "old", keyfp: "fp"}
+	check(s.addOrUpdateHost(host))
+
+	run := newScanRun("discover", ScanConfiguration{
+		Include:     []string{"192.168.1.0/24"},
+		Ports:       []int{22, 2222},
+		Concurrency: 10,
+		Timeout:     4 * time.Second,
+	})
+	check(s.addScanRun(&run))
+	if run.ID == 0 {
+		t.Fatalf("Expected addScanRun to set the run id")
+	}
+	changed := host
+	changed.version = "new"
+	check(s.addHostChanges(run.ID, changed, Host{Hostport: host.hostport, Version: "old", Fingerprint: "fp"}))
+	run.TotalCount = 1
+	run.UpdatedCount = 1
+	check(s.finishScanRun(run))
+
+	runs, err := s.GetScanRuns(10)
+	check(err)
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	got := runs[0]
+	if got.Type != "discover" || got.Ports != "22,2222" || got.TimeoutMs != 4000 || got.UpdatedCount != 1 {
+		t.Errorf("Unexpected run %#v", got)
+	}
+	if got.Started == "" || got.Finished == "" {
+		t.Errorf("Expected start and finish times, got %#v", got)
+	}
+
+	changes, err := s.GetScanRunChanges(run.ID)
+	check(err)
+	if len(changes) != 1 || changes[0].Type != "version" || changes[0].New != "new" {
+		t.Errorf("Unexpected changes %#v", changes)
+	}
+
+	_, err = s.GetScanRun(run.ID + 1)
+	if err == nil {
+		t.Errorf("Expected an error fetching a missing run")
+	}
+}
+
+func TestVulnerabilityLifecycle(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	err = s.Init()
+	check(err)
+
+	cred := Credential{User: "root", Password: "root", ScanInterval: 1}
+	_, err = s.AddCredential(cred)
+	check(err)
+	check(s.addOrUpdateHost(SSHHost{hostport: "192.168.1.1:22", version: "v", keyfp: "fp"}))
+	_, err = s.initHostCreds()
+	check(err)
+
+	result := func(result string, err error) BruteForceResult {
+		return BruteForceResult{hostport: "192.168.1.1:22", cred: cred, result: result, err: err}
+	}
+	state := func() HostCredential {
+		var hc HostCredential
+		check(s.Get(&hc, "SELECT * FROM host_creds"))
+		return hc
+	}
+
+	check(s.updateBruteResult(1, result("", nil)))
+	if hc := state(); hc.State != "" {
+		t.Errorf("Expected no state for a credential that never worked, got %q", hc.State)
+	}
+
+	check(s.updateBruteResult(1, result("exec", nil)))
+	opened := state()
+	if opened.State != VulnOpen || opened.FirstDetected == "" || opened.LastConfirmed == "" || opened.ConfirmedResult != "exec" {
+		t.Errorf("Expected an open vulnerability, got %#v", opened)
+	}
+
+	check(s.updateBruteResult(2, result("", errors.New("connection refused"))))
+	if hc := state(); hc.State != VulnOpen {
+		t.Errorf("Expected an error to leave the vulnerability open, got %q", hc.State)
+	}
+
+	check(s.updateBruteResult(3, result("", nil)))
+	fixed := state()
+	if fixed.State != VulnFixed || fixed.RemediatedAt == "" || fixed.FirstDetected != opened.FirstDetected {
+		t.Errorf("Expected a fixed vulnerability, got %#v", fixed)
+	}
+	if fixed.TimeToRemediate() < 0 {
+		t.Errorf("Expected a non negative time to remediate, got %v", fixed.TimeToRemediate())
+	}
+	vulns, err := s.GetVulnerabilities()
+	check(err)
+	if len(vulns) != 0 {
+		t.Errorf("Expected no open vulnerabilities, got %d", len(vulns))
+	}
+	fixedVulns, err := s.GetFixedVulnerabilities()
+	check(err)
+	if len(fixedVulns) != 1 || fixedVulns[0].HostCredential.ConfirmedResult != "exec" {
+		t.Errorf("Expected 1 fixed vulnerability, got %#v", fixedVulns)
+	}
+
+	check(s.updateBruteResult(4, result("auth", nil)))
+	reopened := state()
+	if reopened.State != VulnReopened || reopened.RemediatedAt != "" || reopened.FirstDetected != opened.FirstDetected {
+		t.Errorf("Expected a reopened vulnerability, got %#v", reopened)
+	}
+}
+
+func TestTimeToRemediate(t *testing.T) {
+	hc := HostCredential{FirstDetected: "2020-01-01 00:00:00", RemediatedAt: "2020-01-03 12:00:00"}
+	if ttr := hc.TimeToRemediate(); ttr != 60*time.Hour {
+		t.Errorf("Expected 60h, got %v", ttr)
+	}
+	hc.RemediatedAt = ""
+	if ttr := hc.TimeToRemediate(); ttr != 0 {
+		t.Errorf("Expected 0 for an unremediated vulnerability, got %v", ttr)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, path, cleanup := newTempSQLiteStore(t)
+	defer cleanup()
+	check(s.Init())
+
+	cred := Credential{User: "root", Password: "root", ScanInterval: 1}
+	_, err := s.AddCredential(cred)
+	check(err)
+	check(s.addOrUpdateHost(SSHHost{hostport: "192.168.1.1:22", version: "v", keyfp: "fp"}))
+	_, err = s.initHostCreds()
+	check(err)
+
+	check(s.Encrypt([]byte("hunter2")))
+	rawPasswords := func() []string {
+		var passwords []string
+		check(s.Select(&passwords, "SELECT password FROM credentials UNION ALL SELECT password FROM host_creds"))
+		return passwords
+	}
+	for _, p := range rawPasswords() {
+		if !isEncrypted(p) {
+			t.Errorf("Expected stored password to be encrypted, got %q", p)
+		}
+	}
+
+	//Added after encryption, so it must be encrypted on the way in
+	cred2 := Credential{User: "admin", Password: "admin", ScanInterval: 1}
+	_, err = s.AddCredential(cred2)
+	check(err)
+	creds, err := s.GetAllCreds()
+	check(err)
+	if len(creds) != 2 {
+		t.Fatalf("Expected 2 creds, got %d", len(creds))
+	}
+	_, err = s.initHostCreds()
+	check(err)
+	queue, err := s.getScanQueue()
+	check(err)
+	if len(queue) != 1 || len(queue[0].credentials) != 2 {
+		t.Fatalf("Expected 1 host with 2 credentials queued, got %#v", queue)
+	}
+	for _, c := range queue[0].credentials {
+		if isEncrypted(c.Password) {
+			t.Errorf("Expected queued password to be decrypted, got %q", c.Password)
+		}
+	}
+	check(s.updateBruteResult(1, BruteForceResult{hostport: "192.168.1.1:22", cred: cred, result: "exec"}))
+	vulns, err := s.GetVulnerabilities()
+	check(err)
+	if len(vulns) != 1 || vulns[0].HostCredential.Password != "root" {
+		t.Errorf("Expected 1 decrypted vulnerability, got %#v", vulns)
+	}
+
+	//A second handle on the same database must be unlocked before use
+	s2, err := NewSQLiteStore(path)
+	check(err)
+	defer s2.Close()
+	check(s2.Init())
+	if _, err := s2.GetAllCreds(); errors.Cause(err) != ErrPassphraseRequired {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err := s2.AddCredential(Credential{User: "guest", Password: "guest"}); errors.Cause(err) != ErrPassphraseRequired {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+	if err := s2.Unlock([]byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	check(s2.Unlock([]byte("hunter2")))
+	check(s2.Rekey([]byte("correct horse")))
+
+	check(s.Unlock([]byte("correct horse")))
+	creds, err = s.GetAllCreds()
+	check(err)
+	if len(creds) != 2 {
+		t.Errorf("Expected 2 creds after rekey, got %d", len(creds))
+	}
+}
+
+func TestEncryptedPrefixPassword(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	check(s.Init())
+
+	//A plaintext password that looks encrypted is still plaintext, before
+	//and after the store is encrypted
+	password := encryptedPrefix + "notciphertext"
+	_, err = s.AddCredential(Credential{User: "root", Password: password, ScanInterval: 1})
+	check(err)
+	for _, step := range []string{"unencrypted", "encrypted"} {
+		creds, err := s.GetAllCreds()
+		check(err)
+		if len(creds) != 1 || creds[0].Password != password {
+			t.Errorf("%s store: expected password %q, got %#v", step, password, creds)
+		}
+		if step == "unencrypted" {
+			check(s.Encrypt([]byte("hunter2")))
+		}
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	check := func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+	s, err := NewSQLiteStore(":memory:")
+	check(err)
+	err = s.Init()
+	check(err)
+
+	host := SSHHost{
+		hostport: "192.168.1.1:22",
+		version:  "v",
+		keyfp:    "SHA256:rsa",
+		keys: []HostKey{
+			{Type: "ssh-rsa", Fingerprint: "SHA256:rsa", Bits: 2048},
+			{Type: "ssh-ed25519", Fingerprint: "SHA256:ed25519", Bits: 256},
+		},
+	}
+	check(s.addOrUpdateHost(host))
+	check(s.setHostKeys(host))
+	known, err := s.getKnownHosts()
+	check(err)
+	rec := known[host.hostport]
+	if len(rec.Keys) != 2 || rec.Keys[0].Type != "ssh-ed25519" || rec.Keys[1].Bits != 2048 || rec.Keys[0].SeenFirst == "" {
+		t.Fatalf("Unexpected keys %#v", rec.Keys)
+	}
+
+	//Rotating the rsa key and adding an ecdsa key replaces the rsa key and
+	//records both changes
+	changed := host
+	changed.keyfp = "SHA256:ecdsa"
+	changed.keys = []HostKey{
+		{Type: "ecdsa-sha2-nistp256", Fingerprint: "SHA256:ecdsa", Bits: 256},
+		{Type: "ssh-rsa", Fingerprint: "SHA256:rsa2", Bits: 4096},
+	}
+	run := newScanRun("discover", ScanConfiguration{})
+	check(s.addScanRun(&run))
+	check(s.addHostChanges(run.ID, changed, rec))
+	check(s.setHostKeys(changed))
+
+	hosts, err := s.GetActiveHosts(1)
+	check(err)
+	if len(hosts) != 1 || hosts[0].Fingerprint != "SHA256:ecdsa" || len(hosts[0].Keys) != 3 {
+		t.Fatalf("Unexpected hosts %#v", hosts)
+	}
+	for _, k := range hosts[0].Keys {
+		if k.Type == "ssh-rsa" && (k.Fingerprint != "SHA256:rsa2" || k.Bits != 4096) {
+			t.Errorf("Expected the rsa key to be replaced, got %#v", k)
+		}
+	}
+	changes, err := s.GetScanRunChanges(run.ID)
+	check(err)
+	types := make(map[string]HostChange)
+	for _, c := range changes {
+		types[c.Type] = c
+	}
+	if len(changes) != 2 ||
+		types["new key"].New != "ecdsa-sha2-nistp256 SHA256:ecdsa" ||
+		types["fingerprint"].Old != "ssh-rsa SHA256:rsa" || types["fingerprint"].New != "ssh-rsa SHA256:rsa2" {
+		t.Errorf("Unexpected changes %#v", changes)
+	}
+
+	check(s.DeleteHost(host.hostport))
+	var remaining int
+	check(s.Get(&remaining, "SELECT count(*) FROM host_keys"))
+	if remaining != 0 {
+		t.Errorf("Expected keys to be deleted with the host, got %d", remaining)
+	}
+}
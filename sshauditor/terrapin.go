@@ -0,0 +1,40 @@
+package sshauditor
+
+import (
+	"strings"
+)
+
+//strictKexServer is the pseudo key exchange algorithm a server advertises
+//when it implements the strict kex countermeasure to Terrapin
+const strictKexServer = "kex-strict-s-v00@openssh.com"
+
+//terrapinModes returns the cipher modes that expose a server offering
+//algos to the Terrapin prefix truncation attack, CVE-2023-48795.  These are
+//chacha20-poly1305 and any encrypt-then-mac MAC combined with a CBC or CTR
+//cipher, unless the server supports strict kex.
+func terrapinModes(algos SSHAlgorithms) []string {
+	for _, k := range algos.Kex {
+		if k == strictKexServer {
+			return nil
+		}
+	}
+	var modes []string
+	var blockCiphers []string
+	for _, c := range algos.Ciphers {
+		switch {
+		case c == "chacha20-poly1305@openssh.com":
+			modes = append(modes, c)
+		case strings.Contains(c, "-cbc"), strings.HasSuffix(c, "-ctr"):
+			blockCiphers = append(blockCiphers, c)
+		}
+	}
+	if len(blockCiphers) == 0 {
+		return modes
+	}
+	for _, m := range algos.MACs {
+		if strings.HasSuffix(m, "-etm@openssh.com") {
+			modes = append(modes, m+" with "+strings.Join(blockCiphers, ","))
+		}
+	}
+	return modes
+}
@@ -0,0 +1,102 @@
+package sshauditor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerrapinModes(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		algos    SSHAlgorithms
+		expected []string
+	}{
+		{
+			"chacha20",
+			SSHAlgorithms{
+				Kex:     []string{"curve25519-sha256"},
+				Ciphers: []string{"chacha20-poly1305@openssh.com", "aes128-gcm@openssh.com"},
+				MACs:    []string{"hmac-sha2-256"},
+			},
+			[]string{"chacha20-poly1305@openssh.com"},
+		},
+		{
+			"etm with ctr",
+			SSHAlgorithms{
+				Kex:     []string{"curve25519-sha256"},
+				Ciphers: []string{"aes128-ctr", "aes256-ctr"},
+				MACs:    []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+			},
+			[]string{"hmac-sha2-256-etm@openssh.com with aes128-ctr,aes256-ctr"},
+		},
+		{
+			"etm with gcm only",
+			SSHAlgorithms{
+				Kex:     []string{"curve25519-sha256"},
+				Ciphers: []string{"aes128-gcm@openssh.com"},
+				MACs:    []string{"hmac-sha2-256-etm@openssh.com"},
+			},
+			nil,
+		},
+		{
+			"strict kex",
+			SSHAlgorithms{
+				Kex:     []string{"curve25519-sha256", "kex-strict-s-v00@openssh.com"},
+				Ciphers: []string{"chacha20-poly1305@openssh.com", "aes128-cbc"},
+				MACs:    []string{"hmac-sha2-256-etm@openssh.com"},
+			},
+			nil,
+		},
+	}
+	for _, tt := range testCases {
+		if got := terrapinModes(tt.algos); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("terrapinModes(%s) => %#v, want %#v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTerrapinFinding(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	a := New(s)
+	host := SSHHost{
+		hostport: "192.168.1.1:22",
+		algos: SSHAlgorithms{
+			Kex:     []string{"curve25519-sha256"},
+			Ciphers: []string{"chacha20-poly1305@openssh.com"},
+			MACs:    []string{"hmac-sha2-256"},
+		},
+	}
+	if err := s.addOrUpdateHost(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.updateAlgorithmFindings(host); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := s.GetFindings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].HostFinding.Type != FindingTerrapin ||
+		findings[0].Host.Hostport != host.hostport || findings[0].FirstFound == "" {
+		t.Fatalf("GetFindings() => %#v", findings)
+	}
+
+	//Patched servers lose the finding
+	host.algos.Kex = append(host.algos.Kex, strictKexServer)
+	if err := a.updateAlgorithmFindings(host); err != nil {
+		t.Fatal(err)
+	}
+	findings, err = s.GetFindings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("GetFindings() after patching => %#v", findings)
+	}
+}